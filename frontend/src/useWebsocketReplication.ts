@@ -35,7 +35,11 @@ export function useWebsocketReplication<T>(collection: T, token: string) {
       AxiosResponse<PostUsersResponse>,
       PostUsersBody
     >("/users", { documents: docs });
-    return data.documents;
+    if (data.errors.length > 0) {
+      console.error("push errors", data.errors);
+    }
+    // RxDB expects the master state of the conflicting documents only
+    return data.conflicts.map((conflict) => conflict.realMasterState);
   };
 
   const pullHandler: ReplicationPullHandler<User, CheckpointType> = async (
@@ -47,6 +51,7 @@ export function useWebsocketReplication<T>(collection: T, token: string) {
     const { data } = await client.get<GetUsersResponse, AxiosResponse<GetUsersResponse>, GetUsersParams>(`/users`, {
       params: {
         minUpdatedAt: minTimestamp,
+        id: lastPulledCheckpoint?.id || "",
         limit: batchSize,
       },
     });
@@ -54,7 +59,7 @@ export function useWebsocketReplication<T>(collection: T, token: string) {
 
     return {
       documents,
-      checkpoint: {
+      checkpoint: data.checkpoint ?? {
         id: lastOfArray(documents)?.id || lastPulledCheckpoint?.id || "",
         updated_at:
           lastOfArray(documents)?.updated_at ||
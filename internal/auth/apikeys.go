@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APIKeysAdminPermission is required to create, list and revoke API keys
+const APIKeysAdminPermission = "apikeys:admin"
+
+// APIKeys resolves the API keys stored in Postgres for middleware.JWTAuth
+type APIKeys struct {
+	repo repository.APIKeyRepository
+}
+
+// NewAPIKeys creates the API key resolver
+func NewAPIKeys(pool *pgxpool.Pool) *APIKeys {
+	return &APIKeys{repo: repository.NewAPIKeyRepository(db.New(pool))}
+}
+
+// ResolveAPIKey implements middleware.APIKeyResolver
+func (k *APIKeys) ResolveAPIKey(ctx context.Context, key string) (*middleware.APIKey, error) {
+	stored, err := k.repo.GetByHash(ctx, hashToken(key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, middleware.ErrAPIKeyInvalid
+	} else if err != nil {
+		return nil, err
+	}
+
+	if stored.RevokedAt.Valid {
+		return nil, fmt.Errorf("%w: key %s revoked", middleware.ErrAPIKeyInvalid, stored.ID)
+	}
+	if stored.ExpiresAt.Valid && time.Now().After(stored.ExpiresAt.Time) {
+		return nil, fmt.Errorf("%w: key %s", middleware.ErrAPIKeyExpired, stored.ID)
+	}
+
+	if err := k.repo.Touch(ctx, stored.ID); err != nil {
+		log.Printf("Recording the use of API key %s failed: %v", stored.ID, err)
+	}
+	return &middleware.APIKey{ID: stored.ID, Name: stored.Name, Scopes: stored.Scopes}, nil
+}
+
+// CreateAPIKeyBody is the body of POST /apikeys
+type CreateAPIKeyBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, the key never expires without it
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse describes an API key. The key itself is only answered on creation.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// RegisterAPIKeys mounts the API key administration on router, which must be
+// authenticated; every endpoint requires APIKeysAdminPermission:
+//   - POST /apikeys: creates a key, answering it once
+//   - GET /apikeys: lists the keys
+//   - DELETE /apikeys/:id: revokes a key
+func RegisterAPIKeys(router fiber.Router, keys *APIKeys) {
+	admin := router.Group("/apikeys", middleware.RequirePermission(APIKeysAdminPermission))
+	admin.Post("/", keys.createHandler)
+	admin.Get("/", keys.listHandler)
+	admin.Delete("/:id", keys.revokeHandler)
+}
+
+func (k *APIKeys) createHandler(c *fiber.Ctx) error {
+	var input CreateAPIKeyBody
+	if err := c.BodyParser(&input); err != nil || input.Name == "" || len(input.Scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "name and scopes are required",
+		})
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+
+	// Nobody hands out more than they are granted themselves
+	roles := middleware.GetUserRolesFromContext(c)
+	scopes := middleware.GetUserScopesFromContext(c)
+	for _, scope := range input.Scopes {
+		if scope == "" || !middleware.Allowed(roles, scopes, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": fmt.Sprintf("can't grant scope %q", scope),
+			})
+		}
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Key generation failed"})
+	}
+	expiresAt := pgtype.Timestamptz{}
+	if input.ExpiresAt != nil {
+		expiresAt = pgtype.Timestamptz{Time: *input.ExpiresAt, Valid: true}
+	}
+
+	stored, err := k.repo.Create(c.Context(), db.ApiKey{
+		ID:        uuid.NewString(),
+		Name:      input.Name,
+		KeyPrefix: key[:len(middleware.APIKeyPrefix)+6],
+		KeyHash:   hashToken(key),
+		Scopes:    input.Scopes,
+		CreatedBy: middleware.GetUserIDFromContext(c),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Creating API key %q failed: %v", input.Name, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	log.Printf("🔑 API key %s (%q) created by %s with scopes %v", stored.ID, stored.Name, stored.CreatedBy, stored.Scopes)
+	resp := toAPIKeyResponse(stored)
+	resp.Key = key
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+func (k *APIKeys) listHandler(c *fiber.Ctx) error {
+	keys, err := k.repo.List(c.Context())
+	if err != nil {
+		log.Printf("Listing API keys failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	result := make([]APIKeyResponse, len(keys))
+	for i, key := range keys {
+		result[i] = toAPIKeyResponse(key)
+	}
+	return c.JSON(result)
+}
+
+func (k *APIKeys) revokeHandler(c *fiber.Ctx) error {
+	revoked, err := k.repo.Revoke(c.Context(), c.Params("id"))
+	if err != nil {
+		log.Printf("Revoking API key %s failed: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+
+	log.Printf("🔒 API key %s revoked by %s", c.Params("id"), middleware.GetUserIDFromContext(c))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// newAPIKey returns a random API key. Like refresh tokens, it is stored as its SHA-256 hash.
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return middleware.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func toAPIKeyResponse(key db.ApiKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		KeyPrefix:  key.KeyPrefix,
+		Scopes:     key.Scopes,
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  timePtr(key.ExpiresAt),
+		LastUsedAt: timePtr(key.LastUsedAt),
+		RevokedAt:  timePtr(key.RevokedAt),
+	}
+}
+
+func timePtr(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// memoryAPIKeys is an in-memory repository.APIKeyRepository
+type memoryAPIKeys struct {
+	keys    map[string]db.ApiKey
+	touched []string
+}
+
+func (m *memoryAPIKeys) Create(_ context.Context, key db.ApiKey) (db.ApiKey, error) {
+	m.keys[key.KeyHash] = key
+	return key, nil
+}
+
+func (m *memoryAPIKeys) GetByHash(_ context.Context, keyHash string) (db.ApiKey, error) {
+	key, ok := m.keys[keyHash]
+	if !ok {
+		return db.ApiKey{}, pgx.ErrNoRows
+	}
+	return key, nil
+}
+
+func (m *memoryAPIKeys) List(context.Context) ([]db.ApiKey, error) {
+	return nil, nil
+}
+
+func (m *memoryAPIKeys) Revoke(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (m *memoryAPIKeys) Touch(_ context.Context, id string) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	repo := &memoryAPIKeys{keys: map[string]db.ApiKey{}}
+	keys := &APIKeys{repo: repo}
+
+	store := func(id string, expiresAt, revokedAt pgtype.Timestamptz) string {
+		key, err := newAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.Create(context.Background(), db.ApiKey{
+			ID:        id,
+			KeyHash:   hashToken(key),
+			Scopes:    []string{"users:read"},
+			ExpiresAt: expiresAt,
+			RevokedAt: revokedAt,
+		})
+		return key
+	}
+	past := pgtype.Timestamptz{Time: time.Now().Add(-time.Hour), Valid: true}
+	future := pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true}
+
+	valid := store("valid", future, pgtype.Timestamptz{})
+	expired := store("expired", past, pgtype.Timestamptz{})
+	revoked := store("revoked", pgtype.Timestamptz{}, past)
+
+	if !strings.HasPrefix(valid, middleware.APIKeyPrefix) {
+		t.Errorf("Expected keys to start with %s, got %s", middleware.APIKeyPrefix, valid)
+	}
+
+	apiKey, err := keys.ResolveAPIKey(context.Background(), valid)
+	if err != nil {
+		t.Fatalf("Expected the key to resolve, got %v", err)
+	}
+	if apiKey.ID != "valid" || len(apiKey.Scopes) != 1 {
+		t.Errorf("Expected the stored key, got %+v", apiKey)
+	}
+	if len(repo.touched) != 1 || repo.touched[0] != "valid" {
+		t.Errorf("Expected the key use recorded, got %v", repo.touched)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want error
+	}{
+		{"expired", expired, middleware.ErrAPIKeyExpired},
+		{"revoked", revoked, middleware.ErrAPIKeyInvalid},
+		{"unknown", middleware.APIKeyPrefix + "unknown", middleware.ErrAPIKeyInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := keys.ResolveAPIKey(context.Background(), tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("Expected %v for the %s key, got %v", tt.want, tt.name, err)
+		}
+	}
+}
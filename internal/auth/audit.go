@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"cognyx/psychic-robot/query"
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AuditReadPermission is required to query the authentication audit log
+const AuditReadPermission = "audit:read"
+
+// Page sizes of GET /audit/auth
+const (
+	defaultAuthEventsLimit = 100
+	maxAuthEventsLimit     = 1000
+)
+
+// AuthEvents persists the authentication audit log in Postgres, as the
+// middleware.AuditStore of middleware.AuthAudit
+type AuthEvents struct {
+	repo repository.AuthEventRepository
+}
+
+// NewAuthEvents creates the audit log store
+func NewAuthEvents(pool *pgxpool.Pool) *AuthEvents {
+	return &AuthEvents{repo: repository.NewAuthEventRepository(db.New(pool))}
+}
+
+// RecordAuthEvent implements middleware.AuditStore
+func (e *AuthEvents) RecordAuthEvent(ctx context.Context, event middleware.AuthEvent) error {
+	stored := db.AuthEvent{
+		CreatedAt:  event.Time,
+		Transport:  string(event.Transport),
+		Method:     string(event.Method),
+		Success:    event.Success,
+		Email:      event.Email,
+		RemoteAddr: event.RemoteAddr,
+		UserAgent:  event.UserAgent,
+		Reason:     event.Reason,
+	}
+	if p := event.Principal; p != nil {
+		stored.PrincipalID = p.ID
+		stored.TenantID = p.TenantID
+		stored.TokenID = p.TokenID
+		if p.Email != "" {
+			stored.Email = p.Email
+		}
+	}
+	return e.repo.Create(ctx, stored)
+}
+
+// PurgeAuthEvents implements middleware.AuditStore
+func (e *AuthEvents) PurgeAuthEvents(ctx context.Context, before time.Time) (int64, error) {
+	return e.repo.Purge(ctx, before)
+}
+
+// AuthEventsQuery are the filters of GET /audit/auth, all optional
+type AuthEventsQuery struct {
+	Transport  *string `query:"transport"`
+	Method     *string `query:"method"`
+	Success    *bool   `query:"success"`
+	Principal  *string `query:"principal"`
+	Email      *string `query:"email"`
+	RemoteAddr *string `query:"remote_addr"`
+	// Since and Until are RFC 3339 timestamps bounding the events' time
+	Since *string `query:"since"`
+	Until *string `query:"until"`
+	// Before is the next_before of the previous page
+	Before *int64 `query:"before"`
+	Limit  *int   `query:"limit"`
+}
+
+// AuthEventsResponse is a page of the audit log, newest events first
+type AuthEventsResponse struct {
+	Events []db.AuthEvent `json:"events"`
+	// NextBefore fetches the next page as ?before=, absent on the last page
+	NextBefore *int64 `json:"next_before,omitempty"`
+	// Dropped is how many events weren't recorded since the server started,
+	// the database not keeping up or failing
+	Dropped int64 `json:"dropped"`
+}
+
+// RegisterAuthEvents mounts the audit log query on router, which must be
+// authenticated:
+//   - GET /audit/auth: lists the authentication events matching the
+//     AuthEventsQuery filters, requiring AuditReadPermission
+func RegisterAuthEvents(router fiber.Router, events *AuthEvents) {
+	router.Get("/audit/auth", middleware.RequirePermission(AuditReadPermission), events.listHandler)
+}
+
+func (e *AuthEvents) listHandler(c *fiber.Ctx) error {
+	var query AuthEventsQuery
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters",
+		})
+	}
+	filter, err := query.filter()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	events, err := e.repo.List(c.UserContext(), filter)
+	if err != nil {
+		log.Printf("Listing auth events failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	resp := AuthEventsResponse{Events: events, Dropped: middleware.DroppedAuthEvents()}
+	if resp.Events == nil {
+		resp.Events = []db.AuthEvent{}
+	}
+	if len(events) == int(filter.RowLimit) {
+		resp.NextBefore = &events[len(events)-1].ID
+	}
+	return c.JSON(resp)
+}
+
+// filter validates the query into the parameters of the ListAuthEvents query
+func (q AuthEventsQuery) filter() (db.ListAuthEventsParams, error) {
+	filter := db.ListAuthEventsParams{
+		Transport:   query.Text(q.Transport),
+		Method:      query.Text(q.Method),
+		PrincipalID: query.Text(q.Principal),
+		Email:       query.Text(q.Email),
+		RemoteAddr:  query.Text(q.RemoteAddr),
+	}
+	if q.Success != nil {
+		filter.Success = pgtype.Bool{Bool: *q.Success, Valid: true}
+	}
+	if q.Before != nil {
+		filter.BeforeID = pgtype.Int8{Int64: *q.Before, Valid: true}
+	}
+
+	var err error
+	if filter.RowLimit, err = query.Limit(q.Limit, defaultAuthEventsLimit, maxAuthEventsLimit); err != nil {
+		return filter, err
+	}
+	filter.Since, filter.Until, err = query.TimeRange(q.Since, q.Until)
+	return filter, err
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"testing"
+	"time"
+)
+
+// memoryAuthEvents is an in-memory repository.AuthEventRepository
+type memoryAuthEvents struct {
+	events []db.AuthEvent
+}
+
+func (m *memoryAuthEvents) Create(_ context.Context, event db.AuthEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *memoryAuthEvents) List(context.Context, db.ListAuthEventsParams) ([]db.AuthEvent, error) {
+	return m.events, nil
+}
+
+func (m *memoryAuthEvents) Purge(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRecordAuthEvent(t *testing.T) {
+	repo := &memoryAuthEvents{}
+	events := &AuthEvents{repo: repo}
+
+	failed := middleware.AuthEvent{
+		Transport:  middleware.TransportHTTP,
+		Method:     middleware.AuthMethodPassword,
+		Email:      "alice@example.com",
+		RemoteAddr: "10.0.0.1",
+		Reason:     "invalid credentials",
+	}
+	succeeded := middleware.AuthEvent{
+		Transport: middleware.TransportSocketIO,
+		Method:    middleware.AuthMethodJWT,
+		Success:   true,
+		Principal: &middleware.Principal{ID: "alice", Email: "alice@example.com", TenantID: "t1", TokenID: "jti-1"},
+	}
+	for _, event := range []middleware.AuthEvent{failed, succeeded} {
+		if err := events.RecordAuthEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := repo.events[0]; got.PrincipalID != "" || got.Email != "alice@example.com" || got.Method != "password" || got.Reason != "invalid credentials" {
+		t.Errorf("Expected the failed login of alice@example.com, got %+v", got)
+	}
+	if got := repo.events[1]; !got.Success || got.Transport != "socketio" || got.PrincipalID != "alice" || got.TenantID != "t1" || got.TokenID != "jti-1" {
+		t.Errorf("Expected the principal of the Socket.IO connection, got %+v", got)
+	}
+}
+
+func TestAuthEventsQueryFilter(t *testing.T) {
+	success := false
+	addr := "10.0.0.1"
+	since := "2026-01-02T15:04:05Z"
+	before := int64(42)
+
+	filter, err := AuthEventsQuery{Success: &success, RemoteAddr: &addr, Since: &since, Before: &before}.filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Success.Valid || filter.Success.Bool || filter.RemoteAddr.String != addr || !filter.RemoteAddr.Valid {
+		t.Errorf("Expected the failures from %s, got %+v", addr, filter)
+	}
+	if !filter.Since.Valid || filter.Since.Time.Year() != 2026 || filter.Until.Valid {
+		t.Errorf("Expected only a lower time bound, got %+v", filter)
+	}
+	if filter.BeforeID.Int64 != 42 || filter.RowLimit != defaultAuthEventsLimit || filter.Transport.Valid {
+		t.Errorf("Expected the default page before event 42, got %+v", filter)
+	}
+
+	badTime := "yesterday"
+	tooMany := maxAuthEventsLimit + 1
+	for _, query := range []AuthEventsQuery{{Until: &badTime}, {Limit: &tooMany}} {
+		if _, err := query.filter(); err == nil {
+			t.Errorf("Expected %+v to be rejected", query)
+		}
+	}
+}
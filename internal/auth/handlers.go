@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when the email is unknown, so that
+// login answers take the same time whether the account exists or not
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// errInvalidRefreshToken rejects unknown, expired and revoked refresh tokens alike
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// LoginBody is the body of POST /auth/login
+type LoginBody struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RefreshBody is the body of POST /auth/refresh and POST /auth/logout
+// (where it is optional)
+type RefreshBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse is answered by the login and refresh endpoints
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Register mounts the token endpoints on router, which must not require authentication:
+//   - POST /login: exchanges an email and password for an access and a refresh token
+//   - POST /refresh: rotates a refresh token, answering a new token pair
+//   - POST /logout: revokes the bearer access token, closing its live connections,
+//     and the refresh token with every token rotated from the same login
+func Register(router fiber.Router, pool *pgxpool.Pool, issuer *Issuer, revocations *middleware.Revocations) {
+	router.Post("/login", loginHandler(pool, issuer))
+	router.Post("/refresh", refreshHandler(pool, issuer))
+	router.Post("/logout", logoutHandler(pool, revocations))
+}
+
+func loginHandler(pool *pgxpool.Pool, issuer *Issuer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input LoginBody
+		if err := c.BodyParser(&input); err != nil || input.Email == "" || input.Password == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "email and password are required",
+			})
+		}
+
+		user, err := repository.NewUserRepository(pool).GetByEmail(c.Context(), input.Email)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Login of %s failed: %v", input.Email, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+
+		if !checkPassword(user, err == nil, input.Password) {
+			log.Printf("Login of %s rejected: invalid credentials", input.Email)
+			reason := "invalid credentials"
+			if err != nil {
+				reason = "unknown email"
+			}
+			middleware.RecordRequestAuth(c, middleware.AuthEvent{
+				Method: middleware.AuthMethodPassword,
+				Email:  input.Email,
+				Reason: reason,
+			})
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid email or password",
+			})
+		}
+
+		tx, err := pool.Begin(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+		defer tx.Rollback(c.Context())
+
+		resp, err := issueTokens(c.Context(), tx, issuer, user, uuid.NewString())
+		if err == nil {
+			err = tx.Commit(c.Context())
+		}
+		if err != nil {
+			log.Printf("Issuing tokens to %s failed: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Token issuing failed"})
+		}
+
+		log.Printf("🔑 User %s (%s) logged in", user.ID, user.Email)
+		middleware.RecordRequestAuth(c, middleware.AuthEvent{
+			Method:    middleware.AuthMethodPassword,
+			Success:   true,
+			Principal: userPrincipal(user, middleware.AuthMethodPassword),
+		})
+		return c.JSON(resp)
+	}
+}
+
+func refreshHandler(pool *pgxpool.Pool, issuer *Issuer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input RefreshBody
+		if err := c.BodyParser(&input); err != nil || input.RefreshToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "refresh_token is required",
+			})
+		}
+
+		tx, err := pool.Begin(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+		defer tx.Rollback(c.Context())
+
+		resp, user, err := rotate(c.Context(), tx, issuer, input.RefreshToken)
+		// A reused token revokes its family: that must be committed as well
+		if err == nil || errors.Is(err, errInvalidRefreshToken) {
+			if commitErr := tx.Commit(c.Context()); commitErr != nil {
+				err = commitErr
+			}
+		}
+		switch {
+		case errors.Is(err, errInvalidRefreshToken):
+			middleware.RecordRequestAuth(c, middleware.AuthEvent{
+				Method: middleware.AuthMethodRefreshToken,
+				Reason: err.Error(),
+			})
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid refresh token",
+			})
+		case err != nil:
+			log.Printf("Token refresh failed: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Token issuing failed"})
+		}
+
+		middleware.RecordRequestAuth(c, middleware.AuthEvent{
+			Method:    middleware.AuthMethodRefreshToken,
+			Success:   true,
+			Principal: userPrincipal(user, middleware.AuthMethodRefreshToken),
+		})
+		return c.JSON(resp)
+	}
+}
+
+func logoutHandler(pool *pgxpool.Pool, revocations *middleware.Revocations) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input RefreshBody
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&input); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "invalid JSON body",
+				})
+			}
+		}
+		accessToken, hasBearer := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if input.RefreshToken == "" && !hasBearer {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "a bearer token or a refresh_token is required",
+			})
+		}
+
+		// An already expired or revoked access token has nothing left to revoke
+		if hasBearer && revocations != nil {
+			if claims, err := middleware.VerifyToken(accessToken); err == nil {
+				if err := revocations.Revoke(c.Context(), claims); err != nil {
+					log.Printf("Revoking the token of %s failed: %v", claims.UserID, err)
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+				}
+			}
+		}
+		if input.RefreshToken == "" {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		// The token stays locked until its family is revoked, like on refresh
+		err := pgx.BeginFunc(c.Context(), pool, func(tx pgx.Tx) error {
+			tokens := repository.NewRefreshTokenRepository(db.New(tx))
+			token, err := tokens.GetByHashForUpdate(c.Context(), hashToken(input.RefreshToken))
+			if err != nil {
+				return err
+			}
+			return tokens.RevokeFamily(c.Context(), token.FamilyID)
+		})
+		// Logging out with an unknown token is a no-op, not an oracle on valid tokens
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Logout failed: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// checkPassword reports whether password matches the user's hash. The bcrypt
+// comparison also runs for unknown users, users without password and deleted users.
+func checkPassword(user db.User, found bool, password string) bool {
+	hash := dummyPasswordHash
+	if found && user.PasswordHash != "" {
+		hash = []byte(user.PasswordHash)
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	return err == nil && found && user.PasswordHash != "" && !user.Deleted
+}
+
+// rotate exchanges a refresh token for a new token pair of the same family,
+// returning the user it was issued to.
+// The token row is locked so that concurrent refreshes can't both succeed.
+// Presenting an already rotated token means it leaked: the whole family is revoked.
+func rotate(ctx context.Context, tx pgx.Tx, issuer *Issuer, refreshToken string) (TokenResponse, db.User, error) {
+	tokens := repository.NewRefreshTokenRepository(db.New(tx))
+	token, err := tokens.GetByHashForUpdate(ctx, hashToken(refreshToken))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TokenResponse{}, db.User{}, fmt.Errorf("%w: unknown token", errInvalidRefreshToken)
+	} else if err != nil {
+		return TokenResponse{}, db.User{}, err
+	}
+
+	if token.RevokedAt.Valid {
+		log.Printf("⚠️ Revoked refresh token reused for user %s, revoking its family", token.UserID)
+		if err := tokens.RevokeFamily(ctx, token.FamilyID); err != nil {
+			return TokenResponse{}, db.User{}, err
+		}
+		return TokenResponse{}, db.User{}, fmt.Errorf("%w: revoked token of user %s reused", errInvalidRefreshToken, token.UserID)
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return TokenResponse{}, db.User{}, fmt.Errorf("%w: token of user %s expired", errInvalidRefreshToken, token.UserID)
+	}
+
+	user, err := repository.NewUserRepository(tx).GetByID(ctx, token.UserID)
+	if err != nil {
+		return TokenResponse{}, db.User{}, err
+	}
+	if user.Deleted {
+		if err := tokens.RevokeFamily(ctx, token.FamilyID); err != nil {
+			return TokenResponse{}, db.User{}, err
+		}
+		return TokenResponse{}, db.User{}, fmt.Errorf("%w: user %s deleted", errInvalidRefreshToken, user.ID)
+	}
+
+	if err := tokens.Revoke(ctx, token.ID); err != nil {
+		return TokenResponse{}, db.User{}, err
+	}
+	resp, err := issueTokens(ctx, tx, issuer, user, token.FamilyID)
+	return resp, user, err
+}
+
+// userPrincipal is the principal of a user authenticated by the token endpoints
+func userPrincipal(user db.User, method middleware.AuthMethod) *middleware.Principal {
+	return &middleware.Principal{ID: user.ID, Email: user.Email, Roles: user.Roles, Method: method}
+}
+
+// issueTokens signs an access token and stores a new refresh token of the family
+func issueTokens(ctx context.Context, tx pgx.Tx, issuer *Issuer, user db.User, familyID string) (TokenResponse, error) {
+	accessToken, _, err := issuer.AccessToken(user)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	_, err = repository.NewRefreshTokenRepository(db.New(tx)).Create(ctx, db.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(issuer.RefreshTokenTTL),
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(issuer.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Default lifetimes of the issued tokens
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Issuer signs the access tokens handed out by the auth endpoints. They carry
+// middleware.JWTClaims, so the JWT middleware must be configured to verify them
+// (same HS256 secret, or the public key matching the private key).
+type Issuer struct {
+	method jwt.SigningMethod
+	key    interface{}
+
+	Issuer          string
+	Audience        string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// NewHS256Issuer creates an issuer signing HS256 tokens with secret
+func NewHS256Issuer(secret []byte) *Issuer {
+	return &Issuer{
+		method:          jwt.SigningMethodHS256,
+		key:             secret,
+		AccessTokenTTL:  DefaultAccessTokenTTL,
+		RefreshTokenTTL: DefaultRefreshTokenTTL,
+	}
+}
+
+// NewIssuer creates an issuer signing RS256 or ES256 tokens with an RSA or
+// ECDSA (P-256) private key
+func NewIssuer(key crypto.Signer) (*Issuer, error) {
+	issuer := &Issuer{
+		key:             key,
+		AccessTokenTTL:  DefaultAccessTokenTTL,
+		RefreshTokenTTL: DefaultRefreshTokenTTL,
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		issuer.method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().Name != "P-256" {
+			return nil, fmt.Errorf("ES256 requires a P-256 key, got %s", k.Curve.Params().Name)
+		}
+		issuer.method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return issuer, nil
+}
+
+// LoadIssuerFromEnv reads the token issuing configuration from the environment:
+//   - JWT_PRIVATE_KEY_FILE: PEM RSA or ECDSA private key signing RS256/ES256 tokens,
+//     JWT_HS256_SECRET is used when it is not set
+//   - JWT_ACCESS_TOKEN_TTL: access token lifetime, as a Go duration (15m by default)
+//   - JWT_REFRESH_TOKEN_TTL: refresh token lifetime, as a Go duration (720h by default)
+//   - JWT_ISSUER, JWT_AUDIENCE: iss and aud claims of the issued tokens
+func LoadIssuerFromEnv() (*Issuer, error) {
+	var issuer *Issuer
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		key, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		if issuer, err = NewIssuer(key); err != nil {
+			return nil, fmt.Errorf("parsing JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+	} else if secret := os.Getenv("JWT_HS256_SECRET"); secret != "" {
+		issuer = NewHS256Issuer([]byte(secret))
+	} else {
+		return nil, fmt.Errorf("no signing key configured, set JWT_PRIVATE_KEY_FILE or JWT_HS256_SECRET")
+	}
+
+	issuer.Issuer = os.Getenv("JWT_ISSUER")
+	issuer.Audience = os.Getenv("JWT_AUDIENCE")
+
+	for name, ttl := range map[string]*time.Duration{
+		"JWT_ACCESS_TOKEN_TTL":  &issuer.AccessTokenTTL,
+		"JWT_REFRESH_TOKEN_TTL": &issuer.RefreshTokenTTL,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", name, err)
+			}
+			*ttl = d
+		}
+	}
+	return issuer, nil
+}
+
+// ParsePrivateKeyPEM parses an RSA or ECDSA private key (PKCS#1, SEC 1 or PKCS#8)
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// AccessToken signs a short-lived access token for the user, returning it
+// with its expiry
+func (i *Issuer) AccessToken(user db.User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(i.AccessTokenTTL)
+
+	claims := middleware.JWTClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		Roles:  user.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   user.ID,
+			Issuer:    i.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	if i.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{i.Audience}
+	}
+
+	token, err := jwt.NewWithClaims(i.method, claims).SignedString(i.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// newRefreshToken returns an opaque random refresh token and the hash under
+// which it is stored: a database leak must not hand out usable tokens
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken is the hex SHA-256 of a refresh token or an API key. Both are
+// random, a slow password hash would add nothing.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testUser = db.User{ID: "user-1", Email: "user@example.com"}
+
+// authenticate sends the access token to a route behind middleware.JWTAuth and
+// returns the authenticated user id
+func authenticate(t *testing.T, token string) (int, string) {
+	t.Helper()
+	var userID string
+	app := fiber.New()
+	app.Get("/", middleware.JWTAuth(), func(c *fiber.Ctx) error {
+		userID = middleware.GetUserIDFromContext(c)
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, userID
+}
+
+func TestAccessTokenVerifiedByMiddleware(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esIssuer, err := NewIssuer(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		issuer *Issuer
+		config middleware.JWTConfig
+	}{
+		{"HS256", NewHS256Issuer([]byte("secret")), middleware.JWTConfig{HMACSecret: []byte("secret")}},
+		{"ES256", esIssuer, middleware.JWTConfig{PublicKey: &ecKey.PublicKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.issuer.Issuer = "psychic-robot"
+			tt.issuer.Audience = "api"
+			tt.config.Issuer = "psychic-robot"
+			tt.config.Audience = "api"
+			tt.config.ClockSkew = middleware.DefaultClockSkew
+			middleware.ConfigureJWT(tt.config)
+
+			token, expiresAt, err := tt.issuer.AccessToken(testUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := time.Until(expiresAt); d <= 0 || d > DefaultAccessTokenTTL {
+				t.Errorf("Expected expiry within %s, got %s", DefaultAccessTokenTTL, d)
+			}
+
+			status, userID := authenticate(t, token)
+			if status != fiber.StatusOK {
+				t.Errorf("Expected status 200, got %d", status)
+			}
+			if userID != testUser.ID {
+				t.Errorf("Expected user %s, got %s", testUser.ID, userID)
+			}
+		})
+	}
+}
+
+func TestExpiredAccessTokenRejected(t *testing.T) {
+	middleware.ConfigureJWT(middleware.JWTConfig{HMACSecret: []byte("secret")})
+	issuer := NewHS256Issuer([]byte("secret"))
+	issuer.AccessTokenTTL = -time.Minute
+
+	token, _, err := issuer.AccessToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := authenticate(t, token); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", status)
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		{Type: "EC PRIVATE KEY", Bytes: ecDER},
+		{Type: "PRIVATE KEY", Bytes: pkcs8DER},
+	}
+	for _, block := range blocks {
+		key, err := ParsePrivateKeyPEM(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("Expected %s to parse, got %v", block.Type, err)
+			continue
+		}
+		if _, err := NewIssuer(key); err != nil {
+			t.Errorf("Expected an issuer for %s, got %v", block.Type, err)
+		}
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not a key")); err == nil {
+		t.Errorf("Expected an error for a non PEM input")
+	}
+}
+
+func TestRefreshTokensAreRandomAndHashed(t *testing.T) {
+	token1, hash1, err := newRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, _, err := newRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token1 == token2 {
+		t.Errorf("Expected distinct refresh tokens, got %s twice", token1)
+	}
+	if hash1 == token1 || len(hash1) != 64 {
+		t.Errorf("Expected a hex SHA-256 hash, got %s", hash1)
+	}
+	if hashToken(token1) != hash1 {
+		t.Errorf("Expected the stored hash to match the token")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.User{ID: "user-1", PasswordHash: string(hash)}
+	deleted := user
+	deleted.Deleted = true
+
+	tests := []struct {
+		name     string
+		user     db.User
+		found    bool
+		password string
+		want     bool
+	}{
+		{"valid password", user, true, "s3cret", true},
+		{"wrong password", user, true, "guess", false},
+		{"unknown user", db.User{}, false, "s3cret", false},
+		{"no password set", db.User{ID: "user-2"}, true, "", false},
+		{"deleted user", deleted, true, "s3cret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkPassword(tt.user, tt.found, tt.password); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"cognyx/psychic-robot/collections"
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TokenRevocations revokes the tokens of other users, e.g. of a compromised account
+type TokenRevocations struct {
+	tokens      repository.RefreshTokenRepository
+	revocations *middleware.Revocations
+}
+
+// NewTokenRevocations creates the token revocation administration
+func NewTokenRevocations(pool *pgxpool.Pool, revocations *middleware.Revocations) *TokenRevocations {
+	return &TokenRevocations{tokens: repository.NewRefreshTokenRepository(db.New(pool)), revocations: revocations}
+}
+
+// RegisterTokenRevocations mounts the token revocation administration on
+// router, which must be authenticated; every endpoint requires
+// collections.UsersAdminPermission:
+//   - DELETE /tokens/users/:id: revokes every refresh token of the user and
+//     the access tokens issued to them so far, closing their live connections
+//   - DELETE /tokens/families/:id: revokes the refresh tokens of a login. Its
+//     access tokens, which don't carry the family, live until their expiry.
+func RegisterTokenRevocations(router fiber.Router, revocations *TokenRevocations) {
+	admin := router.Group("/tokens", middleware.RequirePermission(collections.UsersAdminPermission))
+	admin.Delete("/users/:id", revocations.revokeUserHandler)
+	admin.Delete("/families/:id", revocations.revokeFamilyHandler)
+}
+
+func (r *TokenRevocations) revokeUserHandler(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	refreshTokens, err := r.tokens.RevokeUser(c.Context(), userID)
+	if err != nil {
+		log.Printf("Revoking the refresh tokens of %s failed: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	if err := r.revocations.RevokeSubject(c.Context(), userID); err != nil {
+		log.Printf("Revoking the access tokens of %s failed: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	log.Printf("🔒 Tokens of user %s revoked by %s, %d refresh tokens", userID, middleware.GetUserIDFromContext(c), refreshTokens)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *TokenRevocations) revokeFamilyHandler(c *fiber.Ctx) error {
+	if err := r.tokens.RevokeFamily(c.Context(), c.Params("id")); err != nil {
+		log.Printf("Revoking the refresh token family %s failed: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	log.Printf("🔒 Refresh token family %s revoked by %s", c.Params("id"), middleware.GetUserIDFromContext(c))
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,19 @@
+package collections
+
+import (
+	"cognyx/psychic-robot/versions"
+)
+
+// DatamodelObjectType is the object_type of the datamodel versions
+const DatamodelObjectType = "datamodel"
+
+// DatamodelVersions is the version history of datamodels. Datamodels have no
+// table in this service, only the versions recorded under DatamodelObjectType:
+// their history can be read and diffed, not restored.
+func DatamodelVersions() versions.Object {
+	return versions.Object{
+		Type:           DatamodelObjectType,
+		Path:           "/datamodels",
+		ReadPermission: "datamodels:read",
+	}
+}
@@ -0,0 +1,177 @@
+package collections
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"cognyx/psychic-robot/replication"
+	"cognyx/psychic-robot/types"
+	"cognyx/psychic-robot/versions"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Users is the replicated users collection, streamed on the "sync" event
+// the frontend listens to
+func Users() replication.Collection[db.User, types.User] {
+	return replication.Collection[db.User, types.User]{
+		Name:  "users",
+		Event: "sync",
+		Repository: func(conn db.DBTX) replication.Repository[db.User] {
+			return repository.NewUserRepository(conn)
+		},
+		Mapper:    userMapper{},
+		Validator: types.User.Validate,
+		Access:    userAccess{},
+		OnCreate:  withDefaultRole,
+	}
+}
+
+// UserVersions is the version history of users, readable under the same rule
+// as the replicated records: users read their own, admins every one. Admins
+// restore versions, the restored user being streamed to the clients like a push.
+func UserVersions(s *replication.Server, pool *pgxpool.Pool) versions.Object {
+	return versions.Object{
+		Type:           repository.UserObjectType,
+		Path:           "/users",
+		ReadPermission: "users:read",
+		CanRead: func(p *middleware.Principal, id string) bool {
+			return userAccess{}.CanRead(p, types.User{ID: id})
+		},
+		Restore: func(ctx context.Context, v db.Version) (db.Version, error) {
+			snapshot, err := userSnapshot(v)
+			if err != nil {
+				return db.Version{}, err
+			}
+			user, restored, err := repository.NewUserRepository(pool).Restore(ctx, snapshot)
+			if err != nil {
+				return db.Version{}, err
+			}
+			replication.Publish(s, Users(), user)
+			return restored, nil
+		},
+		RestorePermission: UsersAdminPermission,
+	}
+}
+
+// userSnapshot decodes the snapshot of a user version
+func userSnapshot(v db.Version) (repository.UserSnapshot, error) {
+	var snapshot repository.UserSnapshot
+	if err := json.Unmarshal(v.Json, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("decoding version %d of user %s: %w", v.Version, v.ObjectID, err)
+	}
+	if snapshot.ID != v.ObjectID {
+		return snapshot, fmt.Errorf("version %d of user %s holds user %q", v.Version, v.ObjectID, snapshot.ID)
+	}
+	return snapshot, nil
+}
+
+// UsersAdminPermission lets a principal read and write every user, including roles
+const UsersAdminPermission = "users:admin"
+
+// userAccess lets users replicate their own record only, without changing
+// their role, unless they are granted UsersAdminPermission. API keys aren't
+// users: their users:read and users:write scopes, required by the endpoints
+// already, cover every user, roles still needing UsersAdminPermission.
+type userAccess struct{}
+
+func (userAccess) CanRead(p *middleware.Principal, user types.User) bool {
+	return user.ID == p.ID || isAPIKey(p) || p.Can(UsersAdminPermission)
+}
+
+func (userAccess) CanWrite(p *middleware.Principal, user types.User, master *types.User) bool {
+	if p.Can(UsersAdminPermission) {
+		return true
+	}
+	if user.ID != p.ID && !isAPIKey(p) {
+		return false
+	}
+	// Roles grant permissions: users can't grant themselves some
+	if master == nil {
+		return roleOf(user) == ""
+	}
+	return roleOf(user) == roleOf(*master)
+}
+
+// DefaultUserRole is given to the users created without role by principals who
+// can't grant roles, so that they get the permissions of a user
+const DefaultUserRole = "user"
+
+// withDefaultRole gives DefaultUserRole to a user created without role, unless
+// by a principal granted UsersAdminPermission
+func withDefaultRole(p *middleware.Principal, user types.User) types.User {
+	if roleOf(user) == "" && !p.Can(UsersAdminPermission) {
+		role := DefaultUserRole
+		user.Role = &role
+	}
+	return user
+}
+
+func isAPIKey(p *middleware.Principal) bool {
+	return p != nil && p.Method == middleware.AuthMethodAPIKey
+}
+
+type userMapper struct{}
+
+func (userMapper) ToDocument(user db.User) types.User {
+	tmp := strings.Join(user.Roles, ",")
+	return types.User{
+		ID:        user.ID,
+		Status:    user.Name,
+		Email:     user.Email,
+		Role:      &tmp,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Deleted:   user.Deleted,
+	}
+}
+
+func (userMapper) FromDocument(user types.User) db.User {
+	roles := []string{}
+	if role := roleOf(user); role != "" {
+		roles = strings.Split(role, ",")
+	}
+	return db.User{
+		ID:        user.ID,
+		Name:      user.Status,
+		Email:     user.Email,
+		Roles:     roles,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Deleted:   user.Deleted,
+	}
+}
+
+func (userMapper) Tombstone(id string) types.User {
+	return types.User{ID: id, Deleted: true}
+}
+
+func (userMapper) Meta(user db.User) replication.RowMeta {
+	return replication.RowMeta{
+		ID:        user.ID,
+		UpdatedAt: user.UpdatedAt,
+		Revision:  fmt.Sprintf("%d-%s", user.Revision, user.RevHash),
+		Deleted:   user.Deleted,
+	}
+}
+
+func (userMapper) Equal(a, b types.User) bool {
+	return a.ID == b.ID &&
+		a.Email == b.Email &&
+		a.Status == b.Status &&
+		roleOf(a) == roleOf(b) &&
+		a.CreatedAt.Equal(b.CreatedAt) &&
+		a.UpdatedAt.Equal(b.UpdatedAt) &&
+		a.Deleted == b.Deleted
+}
+
+func roleOf(user types.User) string {
+	if user.Role == nil {
+		return ""
+	}
+	return *user.Role
+}
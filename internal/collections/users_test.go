@@ -0,0 +1,98 @@
+package collections
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/types"
+	"testing"
+)
+
+func TestUserAccess(t *testing.T) {
+	admin := &middleware.Principal{ID: "admin-1", Roles: []string{"admin"}}
+	alice := &middleware.Principal{ID: "alice", Roles: []string{"user"}}
+	apiKey := &middleware.Principal{ID: middleware.APIKeyUserID("key-1"), Scopes: []string{"users:read", "users:write"}, Method: middleware.AuthMethodAPIKey}
+	adminKey := &middleware.Principal{ID: middleware.APIKeyUserID("key-2"), Scopes: []string{UsersAdminPermission}, Method: middleware.AuthMethodAPIKey}
+
+	adminRole := "admin"
+	noRole := ""
+	own := types.User{ID: "alice", Role: &noRole}
+	promoted := types.User{ID: "alice", Role: &adminRole}
+	other := types.User{ID: "bob", Role: &noRole}
+
+	access := userAccess{}
+
+	tests := []struct {
+		name   string
+		p      *middleware.Principal
+		doc    types.User
+		master *types.User
+		read   bool
+		write  bool
+	}{
+		{"own record", alice, own, &own, true, true},
+		{"own record creation", alice, own, nil, true, true},
+		{"own role change", alice, promoted, &own, true, false},
+		{"own creation with a role", alice, promoted, nil, true, false},
+		{"other record", alice, other, &other, false, false},
+		{"admin on other record", admin, promoted, &other, true, true},
+		{"API key on any record", apiKey, other, &other, true, true},
+		{"API key creating a record", apiKey, other, nil, true, true},
+		{"API key changing a role", apiKey, promoted, &own, true, false},
+		{"admin API key changing a role", adminKey, promoted, &own, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := access.CanRead(tt.p, tt.doc); got != tt.read {
+				t.Errorf("Expected CanRead %v, got %v", tt.read, got)
+			}
+			if got := access.CanWrite(tt.p, tt.doc, tt.master); got != tt.write {
+				t.Errorf("Expected CanWrite %v, got %v", tt.write, got)
+			}
+		})
+	}
+}
+
+func TestUsersCreatedWithDefaultRole(t *testing.T) {
+	admin := &middleware.Principal{ID: "admin-1", Roles: []string{"admin"}}
+	alice := &middleware.Principal{ID: "alice", Roles: []string{"user"}}
+	apiKey := &middleware.Principal{ID: middleware.APIKeyUserID("key-1"), Scopes: []string{"users:write"}, Method: middleware.AuthMethodAPIKey}
+
+	adminRole := "admin"
+	noRole := ""
+	tests := []struct {
+		name string
+		p    *middleware.Principal
+		role *string
+		want string
+	}{
+		{"own creation", alice, nil, DefaultUserRole},
+		{"own creation with an empty role", alice, &noRole, DefaultUserRole},
+		{"API key creation", apiKey, nil, DefaultUserRole},
+		{"admin creation without role", admin, nil, ""},
+		{"admin creation with a role", admin, &adminRole, "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := Users().OnCreate(tt.p, types.User{ID: "bob", Role: tt.role})
+			if got := roleOf(user); got != tt.want {
+				t.Errorf("Expected role %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUserSnapshot(t *testing.T) {
+	v := db.Version{ObjectID: "alice", Version: 2, Json: []byte(`{"id":"alice","email":"alice@example.com","roles":["user"],"deleted":true}`)}
+	snapshot, err := userSnapshot(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Email != "alice@example.com" || !snapshot.Deleted || len(snapshot.Roles) != 1 {
+		t.Errorf("Expected the deleted state of alice, got %+v", snapshot)
+	}
+
+	v.ObjectID = "bob"
+	if _, err := userSnapshot(v); err == nil {
+		t.Errorf("Expected the snapshot of another user to be refused")
+	}
+}
@@ -10,102 +10,173 @@ import (
 	"github.com/wI2L/jsondiff"
 )
 
-func CompareJSONFiles(file1, file2 string) (string, error) {
-	// Lire les fichiers
-	json1, err := os.ReadFile(file1)
-	if err != nil {
-		return "", fmt.Errorf("error reading file1: %w", err)
-	}
-	json2, err := os.ReadFile(file2)
-	if err != nil {
-		return "", fmt.Errorf("error reading file2: %w", err)
-	}
+// DiffFormat selects how the changes between two JSON documents are written
+type DiffFormat string
+
+const (
+	// JSONPatch is an RFC 6902 JSON Patch, computed with wI2L/jsondiff
+	JSONPatch DiffFormat = "json-patch"
+	// MergePatch is an RFC 7386 JSON Merge Patch, computed with evanphx/json-patch
+	MergePatch DiffFormat = "merge-patch"
+	// NsfDiff is the human-readable diff of nsf/jsondiff, unchanged fields left out
+	NsfDiff DiffFormat = "nsf"
+)
 
-	// Unmarshal en `any` (interface{})
-	var v1, v2 any
-	if err = json.Unmarshal(json1, &v1); err != nil {
-		return "", fmt.Errorf("error parsing file1: %w", err)
+// ParseDiffFormat validates a diff format, JSONPatch when empty
+func ParseDiffFormat(s string) (DiffFormat, error) {
+	switch format := DiffFormat(s); format {
+	case "":
+		return JSONPatch, nil
+	case JSONPatch, MergePatch, NsfDiff:
+		return format, nil
 	}
-	if err = json.Unmarshal(json2, &v2); err != nil {
-		return "", fmt.Errorf("error parsing file2: %w", err)
+	return "", fmt.Errorf("unknown diff format %q, expected %s, %s or %s", s, JSONPatch, MergePatch, NsfDiff)
+}
+
+// ContentType is the media type of the diffs written in the format
+func (f DiffFormat) ContentType() string {
+	switch f {
+	case MergePatch:
+		return "application/merge-patch+json"
+	case NsfDiff:
+		return "text/plain; charset=utf-8"
 	}
+	return "application/json-patch+json"
+}
 
-	// Comparer avec jsondiff
-	diff, _ := jsondiff.Compare(v1, v2)
-	return diff.String(), nil
+// Diff returns the changes turning the JSON document json1 into json2
+func Diff(json1, json2 []byte, format DiffFormat) ([]byte, error) {
+	switch format {
+	case JSONPatch:
+		return CompareJSON(json1, json2)
+	case MergePatch:
+		return EvanPhxCompareJSON(json1, json2)
+	case NsfDiff:
+		diff, err := NsfCompareJSON(json1, json2)
+		return []byte(diff), err
+	}
+	return nil, fmt.Errorf("unknown diff format %q", format)
 }
 
-func EvanPhxCompareJSONFiles(file1, file2 string) (string, error) {
-	// Lire les fichiers
-	json1, err := os.ReadFile(file1)
+// CompareJSON returns the JSON Patch turning json1 into json2, an empty
+// array when they are equal
+func CompareJSON(json1, json2 []byte) ([]byte, error) {
+	patch, err := compare(json1, json2)
 	if err != nil {
-		return "", fmt.Errorf("error reading file1: %w", err)
+		return nil, err
 	}
-	json2, err := os.ReadFile(file2)
-	if err != nil {
-		return "", fmt.Errorf("error reading file2: %w", err)
+	if patch == nil {
+		patch = jsondiff.Patch{}
 	}
-
-	// Comparer avec jsondiff
-	// Let's create a merge patch from these two documents...
-
-	patch, _ := jsonpatch.CreateMergePatch(json1, json2)
-	return string(patch), nil
+	return json.Marshal(patch)
 }
 
-func NsfCompareJSONFiles(file1, file2 string) (string, error) {
-	// Lire les fichiers
-	json1, err := os.ReadFile(file1)
-	if err != nil {
-		return "", fmt.Errorf("error reading file1: %w", err)
+// ApplyPatch applies JSON Patches, as written by CompareJSON, in order to the
+// JSON document doc. Their operations are applied in one pass: the document is
+// only parsed once.
+func ApplyPatch(doc []byte, patches ...[]byte) ([]byte, error) {
+	var operations jsonpatch.Patch
+	for i, patch := range patches {
+		decoded, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing patch %d: %w", i, err)
+		}
+		operations = append(operations, decoded...)
 	}
-	json2, err := os.ReadFile(file2)
+	if len(operations) == 0 {
+		return doc, nil
+	}
+	patched, err := operations.Apply(doc)
 	if err != nil {
-		return "", fmt.Errorf("error reading file2: %w", err)
+		return nil, fmt.Errorf("error applying patch: %w", err)
 	}
+	return patched, nil
+}
 
-	// Comparer avec jsondiff
-	// Let's create a merge patch from these two documents...
+// EvanPhxCompareJSON returns the merge patch turning json1 into json2
+func EvanPhxCompareJSON(json1, json2 []byte) ([]byte, error) {
+	patch, err := jsonpatch.CreateMergePatch(json1, json2)
+	if err != nil {
+		return nil, fmt.Errorf("error computing merge patch: %w", err)
+	}
+	return patch, nil
+}
 
+// NsfCompareJSON returns the changed fields of json2 against json1, empty
+// when they are equal
+func NsfCompareJSON(json1, json2 []byte) (string, error) {
 	opts := &nsf.Options{
 		SkipMatches: true,
 		Indent:      "  ",
 	}
-	_, diff := nsf.Compare(json1, json2, opts)
-	return diff, nil
+	switch difference, diff := nsf.Compare(json1, json2, opts); difference {
+	case nsf.FullMatch:
+		return "", nil
+	case nsf.FirstArgIsInvalidJson, nsf.BothArgsAreInvalidJson:
+		return "", fmt.Errorf("error parsing json1")
+	case nsf.SecondArgIsInvalidJson:
+		return "", fmt.Errorf("error parsing json2")
+	default:
+		return diff, nil
+	}
 }
 
-/*
-func CompareDBJSONWithFile(ctx context.Context, repo db.VersionRepository, datamodelID int64, filepath string) (string, error) {
-	// 1. Lire le JSON depuis la base (champ json.RawMessage)
-	dbVersion, err := repo.GetLatestByDatamodelID(ctx, datamodelID)
-	if err != nil {
-		return "", fmt.Errorf("failed to get version from DB: %w", err)
+func compare(json1, json2 []byte) (jsondiff.Patch, error) {
+	// Unmarshal en `any` (interface{})
+	var v1, v2 any
+	if err := json.Unmarshal(json1, &v1); err != nil {
+		return nil, fmt.Errorf("error parsing json1: %w", err)
+	}
+	if err := json.Unmarshal(json2, &v2); err != nil {
+		return nil, fmt.Errorf("error parsing json2: %w", err)
 	}
 
-	// 2. Lire le JSON depuis le fichier
-	fileJSON, err := os.ReadFile(filepath)
+	// Comparer avec jsondiff
+	patch, err := jsondiff.Compare(v1, v2)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("error computing diff: %w", err)
 	}
+	return patch, nil
+}
 
-	// 3. Unmarshal les deux
-	var dbData, fileData any
-	if err := json.Unmarshal(dbVersion.Json, &dbData); err != nil {
-		return "", fmt.Errorf("invalid JSON from DB: %w", err)
+func CompareJSONFiles(file1, file2 string) (string, error) {
+	json1, json2, err := readJSONFiles(file1, file2)
+	if err != nil {
+		return "", err
 	}
-	if err := json.Unmarshal(fileJSON, &fileData); err != nil {
-		return "", fmt.Errorf("invalid JSON from file: %w", err)
+	diff, err := compare(json1, json2)
+	if err != nil {
+		return "", err
 	}
+	return diff.String(), nil
+}
 
-	// 4. Diff avec jsondiff
-	opts := jsondiff.DefaultConsoleOptions()
-	diff, err := jsondiff.Compare(dbData, fileData, &opts)
+func EvanPhxCompareJSONFiles(file1, file2 string) (string, error) {
+	json1, json2, err := readJSONFiles(file1, file2)
 	if err != nil {
-		return "", fmt.Errorf("error computing diff: %w", err)
+		return "", err
 	}
+	patch, err := EvanPhxCompareJSON(json1, json2)
+	return string(patch), err
+}
 
-	return diff.String(), nil
+func NsfCompareJSONFiles(file1, file2 string) (string, error) {
+	json1, json2, err := readJSONFiles(file1, file2)
+	if err != nil {
+		return "", err
+	}
+	return NsfCompareJSON(json1, json2)
 }
 
-*/
+func readJSONFiles(file1, file2 string) ([]byte, []byte, error) {
+	// Lire les fichiers
+	json1, err := os.ReadFile(file1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file1: %w", err)
+	}
+	json2, err := os.ReadFile(file2)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file2: %w", err)
+	}
+	return json1, json2, nil
+}
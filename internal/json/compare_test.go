@@ -1,6 +1,7 @@
 package json
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -15,3 +16,68 @@ func BenchmarkNsfCompareJSONFiles(b *testing.B) {
 		NsfCompareJSONFiles("/Users/thomas/go/poc/json/tree.json", "/Users/thomas/go/poc/json/tree2.json")
 	}
 }
+
+func TestDiff(t *testing.T) {
+	v1 := []byte(`{"id":"alice","email":"alice@example.com","roles":["user"]}`)
+	v2 := []byte(`{"id":"alice","email":"alice@example.org","roles":["user"]}`)
+
+	tests := []struct {
+		format DiffFormat
+		want   string
+	}{
+		{JSONPatch, `[{"value":"alice@example.org","op":"replace","path":"/email"}]`},
+		{MergePatch, `{"email":"alice@example.org"}`},
+	}
+	for _, tt := range tests {
+		diff, err := Diff(v1, v2, tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(diff) != tt.want {
+			t.Errorf("Expected the %s %s, got %s", tt.format, tt.want, diff)
+		}
+	}
+
+	diff, err := Diff(v1, v2, NsfDiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(diff), "alice@example.org") || strings.Contains(string(diff), "roles") {
+		t.Errorf("Expected only the changed email, got %s", diff)
+	}
+
+	if same, _ := Diff(v1, v1, JSONPatch); string(same) != "[]" {
+		t.Errorf("Expected an empty patch for equal documents, got %s", same)
+	}
+	if _, err := Diff(v1, []byte(`{`), NsfDiff); err == nil {
+		t.Errorf("Expected invalid JSON to be rejected")
+	}
+}
+
+func TestParseDiffFormat(t *testing.T) {
+	if format, err := ParseDiffFormat(""); err != nil || format != JSONPatch {
+		t.Errorf("Expected JSON Patch by default, got %q %v", format, err)
+	}
+	if _, err := ParseDiffFormat("unified"); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	v1 := []byte(`{"name":"a","tags":["x"]}`)
+	v2 := []byte(`{"name":"b","tags":["x"]}`)
+	v3 := []byte(`{"name":"b","tags":["x","y"]}`)
+	patch12, _ := CompareJSON(v1, v2)
+	patch23, _ := CompareJSON(v2, v3)
+
+	got, err := ApplyPatch(v1, patch12, patch23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := CompareJSON(got, v3); string(same) != "[]" {
+		t.Errorf("Expected %s, got %s", v3, got)
+	}
+	if got, _ := ApplyPatch(v1); string(got) != string(v1) {
+		t.Errorf("Expected the document unchanged without patch, got %s", got)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"cognyx/psychic-robot/auth"
+	"cognyx/psychic-robot/collections"
 	"cognyx/psychic-robot/middleware"
 	"cognyx/psychic-robot/persistence/db"
 	"cognyx/psychic-robot/persistence/repository"
-	"cognyx/psychic-robot/types"
+	"cognyx/psychic-robot/replication"
+	"cognyx/psychic-robot/versions"
 	"context"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -13,7 +16,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/zishang520/socket.io/v2/socket"
 	"log"
-	"strings"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -21,12 +26,68 @@ const GENERATE = false
 
 func main() {
 
+	jwtConfig, err := middleware.LoadJWTConfigFromEnv()
+	if err != nil {
+		log.Fatalf("JWT configuration error: %v", err)
+	}
+	middleware.ConfigureJWT(jwtConfig)
+
+	rolePermissions, err := middleware.LoadRolePermissionsFromEnv()
+	if err != nil {
+		log.Fatalf("RBAC configuration error: %v", err)
+	}
+	middleware.ConfigureRoles(rolePermissions)
+
+	// Without a signing key, tokens are only verified, never issued
+	issuer, err := auth.LoadIssuerFromEnv()
+	if err != nil {
+		log.Printf("Token issuing disabled: %v", err)
+	}
+
 	dbconn := InitDB()
 	defer dbconn.Close()
 
+	// Versions are stored as full snapshots, or as patches between periodic snapshots
+	repository.ConfigureSnapshotInterval(envInt("VERSION_SNAPSHOT_INTERVAL", repository.DefaultSnapshotInterval))
+
 	// Repository
 	queries := db.New(dbconn) // 👈 conversion pool → Queries
-	userRepo := repository.NewUserRepository(queries)
+	userRepo := repository.NewUserRepository(dbconn)
+
+	// Revoked tokens are rejected by every authentication entry point
+	revocations, err := middleware.NewRevocations(context.Background(), repository.NewRevokedTokenRepository(queries))
+	if err != nil {
+		log.Fatalf("Loading revoked tokens failed: %v", err)
+	}
+	middleware.ConfigureRevocations(revocations)
+	go revocations.Run(context.Background(), middleware.DefaultRevocationRefreshInterval)
+
+	// Batch jobs and backend services authenticate with API keys
+	apiKeys := auth.NewAPIKeys(dbconn)
+	middleware.ConfigureAPIKeys(apiKeys)
+
+	// Every authentication decision is persisted for the security team
+	authEvents := auth.NewAuthEvents(dbconn)
+	authAudit := middleware.NewAuthAudit(authEvents, middleware.DefaultAuditBufferSize)
+	middleware.ConfigureAudit(authAudit)
+	go authAudit.Run(context.Background(), time.Duration(envInt("AUTH_AUDIT_RETENTION_DAYS", 90))*24*time.Hour)
+
+	// Versions are written to monthly partitions created ahead; the ones past
+	// the retention are archived to disk
+	archiveDir := os.Getenv("VERSION_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "archive/versions"
+	}
+	versionRetention := versions.NewRetention(dbconn, archiveDir, envInt("VERSION_RETENTION_MONTHS", 0))
+	if err := versionRetention.EnsurePartitions(context.Background()); err != nil {
+		log.Fatalf("Preparing the version table failed: %v", err)
+	}
+	go versionRetention.Run(context.Background(), versions.DefaultRetentionInterval)
+
+	// Tombstones are kept long enough for offline clients to pull them
+	if days := envInt("USER_TOMBSTONE_RETENTION_DAYS", 30); days > 0 {
+		go purgeTombstones(context.Background(), userRepo, time.Duration(days)*24*time.Hour)
+	}
 
 	// SOCKET.IO
 	c := socket.DefaultServerOptions()
@@ -37,40 +98,41 @@ func main() {
 	c.SetConnectTimeout(1000 * time.Millisecond)
 
 	socketio := socket.NewServer(nil, nil)
+	replicationServer := replication.NewServer(dbconn)
 	socketio.On("connection", func(clients ...interface{}) {
 		client := clients[0].(*socket.Socket)
 
 		// Authenticate the Socket.IO connection
-		authData, ok := client.Handshake().Auth.(map[string]interface{})
-		if !ok {
-			log.Printf("Socket.IO authentication failed: invalid auth data format")
-			client.Disconnect(true)
-			return
-		}
-		
-		claims, err := middleware.SocketIOJWTAuth(authData)
+		principal, err := middleware.SocketIOAuthenticate(client, "realtime:connect")
 		if err != nil {
 			log.Printf("Socket.IO authentication failed: %v", err)
 			client.Disconnect(true)
 			return
 		}
 
-		log.Printf("Socket.IO connection authenticated for user: %s (%s)", claims.UserID, claims.Email)
+		log.Printf("Socket.IO connection authenticated for user: %s (%s)", principal.ID, principal.Email)
+
+		// The connection lives as long as its token, which the client can renew
+		watchSocketIOToken(client)
+
+		// Stream the collections the user may read. Events emitted while this client
+		// was disconnected (or before a server restart) are lost: make it pull again
+		replicationServer.Subscribe(client)
 
 		client.On("message", func(args ...interface{}) {
-			log.Printf("Message from user %s: %v", claims.UserID, args)
+			log.Printf("Message from user %s: %v", middleware.GetSocketIOPrincipal(client).ID, args)
 			client.Emit("message-back", args...)
 		})
 		
 		// Emit successful authentication
 		client.Emit("auth", map[string]interface{}{
 			"authenticated": true,
-			"user_id":       claims.UserID,
-			"email":         claims.Email,
+			"user_id":       principal.ID,
+			"email":         principal.Email,
 		})
 
 		client.On("message-with-ack", func(args ...interface{}) {
-			log.Printf("Message with ACK from user %s: %v", claims.UserID, args)
+			log.Printf("Message with ACK from user %s: %v", middleware.GetSocketIOPrincipal(client).ID, args)
 			ack := args[len(args)-1].(socket.Ack)
 			ack(args[:len(args)-1], nil)
 		})
@@ -80,26 +142,21 @@ func main() {
 		client := clients[0].(*socket.Socket)
 		
 		// Authenticate the Socket.IO connection for custom namespace
-		authData, ok := client.Handshake().Auth.(map[string]interface{})
-		if !ok {
-			log.Printf("Socket.IO /custom authentication failed: invalid auth data format")
-			client.Disconnect(true)
-			return
-		}
-		
-		claims, err := middleware.SocketIOJWTAuth(authData)
+		principal, err := middleware.SocketIOAuthenticate(client, "realtime:connect")
 		if err != nil {
 			log.Printf("Socket.IO /custom authentication failed: %v", err)
 			client.Disconnect(true)
 			return
 		}
 
-		log.Printf("Socket.IO /custom connection authenticated for user: %s (%s)", claims.UserID, claims.Email)
+		log.Printf("Socket.IO /custom connection authenticated for user: %s (%s)", principal.ID, principal.Email)
+
+		watchSocketIOToken(client)
 		
 		client.Emit("auth", map[string]interface{}{
 			"authenticated": true,
-			"user_id":       claims.UserID,
-			"email":         claims.Email,
+			"user_id":       principal.ID,
+			"email":         principal.Email,
 		})
 	})
 
@@ -114,105 +171,67 @@ func main() {
 	app.Get("/socket.io", adaptor.HTTPHandler(socketio.ServeHandler(c)))
 	app.Post("/socket.io", adaptor.HTTPHandler(socketio.ServeHandler(c)))
 
-	// Example User endpoints with JWT authentication
-	app.Get("/api/users", middleware.JWTAuth(), func(c *fiber.Ctx) error {
-		userID := middleware.GetUserIDFromContext(c)
-		userEmail := middleware.GetUserEmailFromContext(c)
-		
-		log.Printf("🚀 GET REQUEST ON /api/users from user: %s (%s)", userID, userEmail)
-		
-		users, err := userRepo.List(c.Context(), 25, 0)
-		resp := types.GetUsersResponse{}
-		resp.Documents = mapUsersToUsers(users)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
-		}
-		log.Println("🚀 GET REQUEST ON http://localhost:4000/api/users ---> SUCCESS")
-		return c.JSON(resp)
-	})
-
-	// Example User endpoints with JWT authentication
-	app.Post("/api/users", middleware.JWTAuth(), func(c *fiber.Ctx) error {
-		var input types.PostUsersBody
-		userID := middleware.GetUserIDFromContext(c)
-		userEmail := middleware.GetUserEmailFromContext(c)
-		
-		log.Printf("🚀 POST REQUEST ON /api/users from user: %s (%s)", userID, userEmail)
+	// Login, refresh and logout, hence not behind the JWT middleware
+	if issuer != nil {
+		auth.Register(app.Group("/auth"), dbconn, issuer, revocations)
+	}
 
-		if err := c.BodyParser(&input); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid JSON body",
-			})
-		}
-
-		resp := types.ReplicationPushHandlerResult{}
-		resp.Documents = make([]types.User, 0)
-		resp.Errors = make([]types.ReplicationError, 0)
-
-		for _, rxReplicationWriteToMasterRow := range input.Documents {
-			user := db.User{
-				ID:        rxReplicationWriteToMasterRow.NewDocumentState.ID,
-				Name:      rxReplicationWriteToMasterRow.NewDocumentState.Status,
-				Email:     rxReplicationWriteToMasterRow.NewDocumentState.Email,
-				Roles:     []string{},
-				CreatedAt: rxReplicationWriteToMasterRow.NewDocumentState.CreatedAt,
-				UpdatedAt: rxReplicationWriteToMasterRow.NewDocumentState.UpdatedAt,
-			}
-			user, err := userRepo.Create(c.Context(), user)
-			if err != nil {
-				resp.Errors = append(resp.Errors, types.ReplicationError{
-					DocumentID: rxReplicationWriteToMasterRow.NewDocumentState.ID,
-					Error:      err.Error(),
-					Status:     0,
-				})
-				continue
-			}
-			resp.Documents = append(resp.Documents, mapUserToUser(user))
-		}
-		log.Println("🚀 POST REQUEST ON http://localhost:4000/api/users ---> SUCCESS")
-
-		// 🔥 Emit update to all connected clients
-		rxDocumentData := mapDocumentsToRxDocumentData(resp.Documents)
-		toStream := types.UsersStreamEvent{Data: types.RxReplicationPullStreamItem{
-			Documents: rxDocumentData,
-			Checkpoint: types.CheckpointType{
-				UpdatedAt: time.Now().String(),
-				ID:        "titi",
-			},
-		}}
-		socketio.Sockets().Emit("sync", toStream)
-		return c.Status(fiber.StatusCreated).JSON(resp)
-	})
+	// Replicated collections, pulled and pushed under /api, the version history of
+	// users and datamodels, the API key and token revocation administration and
+	// the authentication audit log
+	api := app.Group("/api", middleware.JWTAuth())
+	replication.Register(replicationServer, api, collections.Users())
+	versions.Register(api, dbconn, collections.UserVersions(replicationServer, dbconn))
+	versions.Register(api, dbconn, collections.DatamodelVersions())
+	auth.RegisterAPIKeys(api, apiKeys)
+	auth.RegisterTokenRevocations(api, auth.NewTokenRevocations(dbconn, revocations))
+	auth.RegisterAuthEvents(api, authEvents)
 
 	// WebSocket endpoint with JWT authentication
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
-			// Apply JWT authentication for WebSocket upgrade
-			if err := middleware.WSJWTAuth(c); err != nil {
-				return err
-			}
-			c.Locals("allowed", true)
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
+	}, middleware.WSJWTAuth, middleware.RequirePermission("realtime:connect"), func(c *fiber.Ctx) error {
+		// Only reached once authenticated and authorized
+		c.Locals("allowed", true)
+		return c.Next()
 	})
 
 	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
-		// Get user information from Fiber locals (set during upgrade)
-		userID, _ := c.Locals("user_id").(string)
-		userEmail, _ := c.Locals("user_email").(string)
-		
-		if userID == "" {
-			userID = "unknown"
-		}
+		// Get the principal authenticated during the upgrade
+		principal := middleware.GetWSPrincipal(c)
+		userID, userEmail := principal.ID, principal.Email
 		if userEmail == "" {
 			userEmail = "unknown"
 		}
 		
 		log.Printf("WebSocket connection established for user: %s (%s)", userID, userEmail)
+
+		// Timers write to the connection as well
+		var writeMu sync.Mutex
+		writeJSON := func(v interface{}) error {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			return c.WriteJSON(v)
+		}
+
+		// The connection lives as long as its token, which the client can renew
+		// with a {"type": "reauth", "token": "..."} message. Closing it ends the read loop below
+		session := middleware.NewSession(principal, "realtime:connect", middleware.SessionHooks{
+			Expiring: func(expiresAt time.Time) {
+				writeJSON(map[string]interface{}{"type": "token-expiring", "expires_at": expiresAt})
+			},
+			Closed: func(reason error) {
+				log.Printf("Closing WebSocket connection of user %s: %v", userID, reason)
+				c.Close()
+			},
+		})
+		defer session.Close()
 		
 		// Send welcome message
-		c.WriteJSON(map[string]interface{}{
+		writeJSON(map[string]interface{}{
 			"type": "welcome",
 			"message": "WebSocket connection authenticated",
 			"user_id": userID,
@@ -229,6 +248,12 @@ func main() {
 
 			log.Printf("Received WebSocket message from user %s: %v", userID, msg)
 
+			if msg["type"] == "reauth" {
+				token, _ := msg["token"].(string)
+				writeJSON(reauthResult(session, token, middleware.WSAuthEvent(c)))
+				continue
+			}
+
 			// Echo message back with user info
 			response := map[string]interface{}{
 				"type": "echo",
@@ -237,7 +262,7 @@ func main() {
 				"timestamp": time.Now().Format(time.RFC3339),
 			}
 			
-			if err := c.WriteJSON(response); err != nil {
+			if err := writeJSON(response); err != nil {
 				log.Printf("Error sending WebSocket message to user %s: %v", userID, err)
 				break
 			}
@@ -252,59 +277,103 @@ func main() {
 	log.Fatal(app.Listen(":4000"))
 }
 
-func mapDocumentsToRxDocumentData(users []types.User) []types.RxDocumentData {
-	result := make([]types.RxDocumentData, len(users))
-
-	for i, user := range users {
-		result[i] = types.RxDocumentData{
-			User: types.User{
-				ID:        user.ID,
-				Email:     user.Email,
-				Status:    user.Status,
-				Role:      user.Role,
-				CreatedAt: user.CreatedAt,
-				UpdatedAt: user.UpdatedAt,
-				Deleted:   user.Deleted,
-			},
-			Rev:     "1-" + user.ID, // dummy rev, replace with real revision logic if needed
-			Deleted: user.Deleted,
-			Meta:    nil, // optional: set if you have metadata
+// watchSocketIOToken disconnects the client once its token expired or got revoked.
+// The client is sent "token-expiring" beforehand, and renews its token by
+// emitting "reauth" with the fresh token (answered through the ack, or a "reauth" event).
+// A successful reauth replaces the principal of the client.
+func watchSocketIOToken(client *socket.Socket) {
+	session := middleware.NewSession(middleware.GetSocketIOPrincipal(client), "realtime:connect", middleware.SessionHooks{
+		Expiring: func(expiresAt time.Time) {
+			client.Emit("token-expiring", map[string]interface{}{"expires_at": expiresAt})
+		},
+		Closed: func(reason error) {
+			log.Printf("Disconnecting Socket.IO client of user %s: %v", middleware.GetSocketIOPrincipal(client).ID, reason)
+			client.Disconnect(true)
+		},
+	})
+	client.On("disconnect", func(...interface{}) { session.Close() })
+
+	client.On("reauth", func(args ...interface{}) {
+		var ack socket.Ack
+		if len(args) > 0 {
+			if fn, ok := args[len(args)-1].(socket.Ack); ok {
+				ack, args = fn, args[:len(args)-1]
+			}
+		}
+
+		// Either the token itself or {"token": "..."}
+		var token string
+		if len(args) > 0 {
+			switch arg := args[0].(type) {
+			case string:
+				token = arg
+			case map[string]interface{}:
+				token, _ = arg["token"].(string)
+			}
 		}
-	}
 
-	return result
+		result := reauthResult(session, token, middleware.SocketIOAuthEvent(client))
+		if result["authenticated"] == true {
+			middleware.SetSocketIOPrincipal(client, session.Principal())
+		}
+		if ack != nil {
+			ack([]interface{}{result}, nil)
+		} else {
+			client.Emit("reauth", result)
+		}
+	})
 }
 
-func mapUserToUser(user db.User) types.User {
-
-	tmp := strings.Join(user.Roles, ",")
-	result := types.User{
-		ID:        user.ID,
-		Status:    user.Name,
-		Email:     user.Email,
-		Role:      &tmp,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Deleted:   false,
+// reauthResult renews the token of a session, answering whether it succeeded.
+// The decision is recorded as event, which carries the connection details.
+func reauthResult(session *middleware.Session, token string, event middleware.AuthEvent) map[string]interface{} {
+	event.Method = middleware.AuthMethodJWT
+	principal, err := session.Reauth(token)
+	if err != nil {
+		log.Printf("Re-authentication of user %s failed: %v", session.Principal().ID, err)
+		event.Principal, event.Reason = session.Principal(), "reauth: "+err.Error()
+		middleware.RecordAuthEvent(event)
+		return map[string]interface{}{"type": "reauth", "authenticated": false, "error": err.Error()}
 	}
-	return result
+	event.Principal, event.Success = principal, true
+	middleware.RecordAuthEvent(event)
+	return map[string]interface{}{"type": "reauth", "authenticated": true, "expires_at": principal.ExpiresAt}
 }
 
-func mapUsersToUsers(users []db.User) []types.User {
-	result := make([]types.User, len(users))
-	for i, user := range users {
-		tmp := strings.Join(user.Roles, ",")
-		result[i] = types.User{
-			ID:        user.ID,
-			Status:    user.Name,
-			Email:     user.Email,
-			Role:      &tmp,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Deleted:   false,
+// purgeTombstones hourly removes the users soft-deleted for longer than retention.
+// A client offline for longer than that will never learn about those deletions.
+func purgeTombstones(ctx context.Context, userRepo repository.UserRepository, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := userRepo.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Tombstone purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("🧹 Purged %d user tombstones older than %s", purged, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
-	return result
+}
+
+// envInt reads an integer setting from the environment, falling back to def
+func envInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, value, err)
+		return def
+	}
+	return n
 }
 
 func InitDB() *pgxpool.Pool {
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeAPIKeys resolves a fixed set of keys
+type fakeAPIKeys map[string]error
+
+func (k fakeAPIKeys) ResolveAPIKey(_ context.Context, key string) (*APIKey, error) {
+	err, ok := k[key]
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &APIKey{ID: "key-1", Name: "batch", Scopes: []string{"users:read"}}, nil
+}
+
+func useAPIKeys(t *testing.T, resolver APIKeyResolver) {
+	previous := apiKeyResolver
+	ConfigureAPIKeys(resolver)
+	t.Cleanup(func() { ConfigureAPIKeys(previous) })
+}
+
+func TestJWTAuth_APIKey(t *testing.T) {
+	useAPIKeys(t, fakeAPIKeys{
+		APIKeyPrefix + "valid":   nil,
+		APIKeyPrefix + "expired": ErrAPIKeyExpired,
+	})
+
+	app := fiber.New()
+	app.Get("/users", JWTAuth(), RequirePermission("users:read"), func(c *fiber.Ctx) error {
+		return c.SendString(GetUserIDFromContext(c))
+	})
+	app.Post("/users", JWTAuth(), RequirePermission("users:write"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		want   int
+	}{
+		{"X-API-Key header", "GET", "X-API-Key", APIKeyPrefix + "valid", fiber.StatusOK},
+		{"bearer prefix", "GET", "Authorization", "Bearer " + APIKeyPrefix + "valid", fiber.StatusOK},
+		{"scope not granted", "POST", "X-API-Key", APIKeyPrefix + "valid", fiber.StatusForbidden},
+		{"unknown key", "GET", "X-API-Key", APIKeyPrefix + "unknown", fiber.StatusUnauthorized},
+		{"expired key", "GET", "Authorization", "Bearer " + APIKeyPrefix + "expired", fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/users", nil)
+			req.Header.Set(tt.header, tt.value)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestJWTAuth_APIKeyIdentity(t *testing.T) {
+	useAPIKeys(t, fakeAPIKeys{APIKeyPrefix + "valid": nil})
+
+	app := fiber.New()
+	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"user_id": GetUserIDFromContext(c),
+			"scopes":  GetUserScopesFromContext(c),
+		})
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-API-Key", APIKeyPrefix+"valid")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var body struct {
+		UserID string   `json:"user_id"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.UserID != APIKeyUserID("key-1") {
+		t.Errorf("Expected user %s, got %s", APIKeyUserID("key-1"), body.UserID)
+	}
+	if len(body.Scopes) != 1 || body.Scopes[0] != "users:read" {
+		t.Errorf("Expected the key scopes, got %v", body.Scopes)
+	}
+}
+
+func TestJWTAuth_APIKeysDisabled(t *testing.T) {
+	useAPIKeys(t, nil)
+
+	app := fiber.New()
+	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-API-Key", APIKeyPrefix+"valid")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// Transport is the kind of connection an authentication happened on
+type Transport string
+
+// Transports
+const (
+	TransportHTTP      Transport = "http"
+	TransportWebSocket Transport = "ws"
+	TransportSocketIO  Transport = "socketio"
+)
+
+// Authentication methods of the login endpoints, besides AuthMethodJWT and AuthMethodAPIKey
+const (
+	AuthMethodPassword     AuthMethod = "password"
+	AuthMethodRefreshToken AuthMethod = "refresh_token"
+)
+
+// DefaultAuditBufferSize is how many authentication events can wait to be persisted
+const DefaultAuditBufferSize = 1024
+
+// DefaultAuditFailureWait is how long recording a failure waits for room in a
+// full buffer before dropping it
+const DefaultAuditFailureWait = 100 * time.Millisecond
+
+// DefaultAuditStoreTimeout bounds the persistence of an event
+const DefaultAuditStoreTimeout = 5 * time.Second
+
+// AuthEvent is an authentication decision, successful or not
+type AuthEvent struct {
+	Time      time.Time
+	Transport Transport
+	Method    AuthMethod
+	Success   bool
+	// Principal is the authenticated caller, nil on failures
+	Principal *Principal
+	// Email is the identity attempted, e.g. the email of a failed login
+	Email      string
+	RemoteAddr string
+	UserAgent  string
+	// Reason is why the authentication failed, or the permission refused
+	Reason string
+}
+
+// AuditStore persists the authentication events, e.g. the auth_events table
+type AuditStore interface {
+	RecordAuthEvent(ctx context.Context, event AuthEvent) error
+	PurgeAuthEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuthAudit persists the authentication events in the background. Successful
+// events are dropped when the store can't keep up, so that authenticating never
+// waits for it. Recording a failure waits a little for room in the buffer
+// instead, slowing down floods of failures without stalling them on a hung
+// database. Dropped events and the ones the store failed to persist are
+// logged and counted.
+type AuthAudit struct {
+	store   AuditStore
+	events  chan AuthEvent
+	dropped atomic.Int64
+	// failureWait is how long a failure waits for room in the buffer
+	failureWait time.Duration
+	// storeTimeout bounds each call to the store
+	storeTimeout time.Duration
+}
+
+var authAudit *AuthAudit
+
+// ConfigureAudit sets the audit log every authentication entry point records
+// to. It must be called at startup; without it nothing is recorded.
+func ConfigureAudit(a *AuthAudit) {
+	authAudit = a
+}
+
+// NewAuthAudit creates an audit log buffering up to bufferSize events
+func NewAuthAudit(store AuditStore, bufferSize int) *AuthAudit {
+	return &AuthAudit{
+		store:        store,
+		events:       make(chan AuthEvent, bufferSize),
+		failureWait:  DefaultAuditFailureWait,
+		storeTimeout: DefaultAuditStoreTimeout,
+	}
+}
+
+// Record queues an event, stamping it with the current time when it has none
+func (a *AuthAudit) Record(event AuthEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Success {
+		select {
+		case a.events <- event:
+		default:
+			a.drop(event, "buffer full")
+		}
+		return
+	}
+
+	timer := time.NewTimer(a.failureWait)
+	defer timer.Stop()
+	select {
+	case a.events <- event:
+	case <-timer.C:
+		a.drop(event, "buffer full")
+	}
+}
+
+// drop counts and logs an event that won't be persisted
+func (a *AuthAudit) drop(event AuthEvent, reason string) {
+	a.dropped.Add(1)
+	log.Printf("Auth audit %s, dropping %s %s event of %q (success: %t)",
+		reason, event.Transport, event.Method, event.RemoteAddr, event.Success)
+}
+
+// Dropped returns how many events weren't persisted since the audit log started
+func (a *AuthAudit) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Run persists the queued events until ctx is done. Events older than
+// retention are purged hourly, unless retention is not positive.
+func (a *AuthAudit) Run(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-a.events:
+			a.persist(ctx, event)
+		case <-ticker.C:
+			if retention <= 0 {
+				continue
+			}
+			if purged, err := a.store.PurgeAuthEvents(ctx, time.Now().Add(-retention)); err != nil {
+				log.Printf("Auth events purge failed: %v", err)
+			} else if purged > 0 {
+				log.Printf("🧹 Purged %d auth events older than %s", purged, retention)
+			}
+		}
+	}
+}
+
+// persist stores an event, dropping it when the store fails or hangs
+func (a *AuthAudit) persist(ctx context.Context, event AuthEvent) {
+	ctx, cancel := context.WithTimeout(ctx, a.storeTimeout)
+	defer cancel()
+	if err := a.store.RecordAuthEvent(ctx, event); err != nil {
+		a.drop(event, fmt.Sprintf("store failed (%v)", err))
+	}
+}
+
+// RecordAuthEvent records an event to the configured audit log
+func RecordAuthEvent(event AuthEvent) {
+	if authAudit != nil {
+		authAudit.Record(event)
+	}
+}
+
+// DroppedAuthEvents returns how many events the configured audit log dropped
+func DroppedAuthEvents() int64 {
+	if authAudit == nil {
+		return 0
+	}
+	return authAudit.Dropped()
+}
+
+// RecordRequestAuth records an event of an HTTP request or WebSocket upgrade,
+// filling in its transport, remote address and user agent
+func RecordRequestAuth(c *fiber.Ctx, event AuthEvent) {
+	event.Transport = TransportHTTP
+	if websocket.IsWebSocketUpgrade(c) {
+		event.Transport = TransportWebSocket
+	}
+	event.RemoteAddr = c.IP()
+	event.UserAgent = c.Get(fiber.HeaderUserAgent)
+	RecordAuthEvent(event)
+}
+
+// SocketIOAuthEvent returns an event of a Socket.IO connection, with its
+// transport, remote address and user agent filled in
+func SocketIOAuthEvent(client *socket.Socket) AuthEvent {
+	handshake := client.Handshake()
+	return AuthEvent{
+		Transport:  TransportSocketIO,
+		RemoteAddr: handshake.Address,
+		UserAgent:  http.Header(handshake.Headers).Get(fiber.HeaderUserAgent),
+	}
+}
+
+// WSAuthEvent returns an event of an upgraded WebSocket connection, with its
+// transport, remote address and user agent filled in
+func WSAuthEvent(c *websocket.Conn) AuthEvent {
+	return AuthEvent{
+		Transport:  TransportWebSocket,
+		RemoteAddr: c.IP(),
+		UserAgent:  c.Headers(fiber.HeaderUserAgent),
+	}
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryAuditStore is an in-memory AuditStore
+type memoryAuditStore struct {
+	mu     sync.Mutex
+	events []AuthEvent
+}
+
+func (m *memoryAuditStore) RecordAuthEvent(_ context.Context, event AuthEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *memoryAuditStore) PurgeAuthEvents(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *memoryAuditStore) recorded() []AuthEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuthEvent(nil), m.events...)
+}
+
+// useAudit configures an audit log whose queued events are read from the returned channel
+func useAudit(t *testing.T, bufferSize int) *AuthAudit {
+	a := NewAuthAudit(&memoryAuditStore{}, bufferSize)
+	previous := authAudit
+	ConfigureAudit(a)
+	t.Cleanup(func() { ConfigureAudit(previous) })
+	return a
+}
+
+func nextEvent(t *testing.T, a *AuthAudit) AuthEvent {
+	t.Helper()
+	select {
+	case event := <-a.events:
+		return event
+	default:
+		t.Fatalf("Expected an auth event to be recorded")
+		return AuthEvent{}
+	}
+}
+
+func TestJWTAuthRecordsDecisions(t *testing.T) {
+	useJWTConfig(t, JWTConfig{HMACSecret: testSecret})
+	useRoles(t, RolePermissions{"user": {"users:read"}})
+	a := useAudit(t, 8)
+
+	app := fiber.New()
+	app.Get("/", JWTAuth(), RequirePermission("users:write"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	send := func(token string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("User-Agent", "brute/1.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("garbage")
+	failed := nextEvent(t, a)
+	if failed.Success || failed.Transport != TransportHTTP || failed.Method != AuthMethodJWT {
+		t.Errorf("Expected a failed HTTP JWT authentication, got %+v", failed)
+	}
+	if failed.UserAgent != "brute/1.0" || failed.RemoteAddr == "" || !strings.Contains(failed.Reason, "malformed") {
+		t.Errorf("Expected the request details and the reason, got %+v", failed)
+	}
+
+	// Successful requests aren't recorded, the refused permission is
+	claims := validClaims()
+	claims.Roles = []string{"user"}
+	send(signHS256(t, claims))
+	denied := nextEvent(t, a)
+	if denied.Success || denied.Principal == nil || denied.Reason != "permission users:write required" {
+		t.Errorf("Expected the refused permission to be recorded, got %+v", denied)
+	}
+}
+
+func TestAuthAuditDropsSuccessesOnly(t *testing.T) {
+	store := &memoryAuditStore{}
+	a := NewAuthAudit(store, 1)
+	a.Record(AuthEvent{Success: true, Reason: "first"})
+	a.Record(AuthEvent{Success: true, Reason: "dropped"})
+	if a.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", a.Dropped())
+	}
+
+	// The buffer is full: the failure waits a while for the store
+	recorded := make(chan struct{})
+	go func() {
+		a.Record(AuthEvent{Reason: "failure"})
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+		t.Fatalf("Expected the failure to wait for room in the buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, 0)
+		close(done)
+	}()
+	<-recorded
+
+	deadline := time.Now().Add(time.Second)
+	for len(store.recorded()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	events := store.recorded()
+	if len(events) != 2 || events[0].Reason != "first" || events[1].Reason != "failure" {
+		t.Fatalf("Expected the first success and the failure persisted, got %+v", events)
+	}
+	if events[0].Time.IsZero() {
+		t.Errorf("Expected the event to be timestamped")
+	}
+}
+
+// blockedAuditStore is an AuditStore hung until the context of the call is done
+type blockedAuditStore struct{}
+
+func (blockedAuditStore) RecordAuthEvent(ctx context.Context, _ AuthEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockedAuditStore) PurgeAuthEvents(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestAuthAuditDoesNotBlockOnHungStore(t *testing.T) {
+	a := NewAuthAudit(blockedAuditStore{}, 1)
+	a.failureWait = 10 * time.Millisecond
+	a.storeTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, 0)
+
+	recorded := make(chan struct{})
+	go func() {
+		// The first is hung in the store, the second fills the buffer
+		for range 3 {
+			a.Record(AuthEvent{Reason: "failure"})
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected recording failures to return while the store hangs")
+	}
+	if a.Dropped() < 1 {
+		t.Errorf("Expected the failure finding no room to be counted, got %d", a.Dropped())
+	}
+
+	// The store calls time out, the events they held are counted too
+	deadline := time.Now().Add(time.Second)
+	for a.Dropped() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a.Dropped() != 3 {
+		t.Errorf("Expected the 3 failures counted as dropped, got %d", a.Dropped())
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultClockSkew is the tolerance applied to exp, nbf and iat
+const DefaultClockSkew = 30 * time.Second
+
+// JWTConfig configures how bearer tokens are verified
+type JWTConfig struct {
+	// HMACSecret verifies HS256 tokens
+	HMACSecret []byte
+	// PublicKey verifies RS256 (*rsa.PublicKey) or ES256 (*ecdsa.PublicKey) tokens
+	PublicKey crypto.PublicKey
+	// KeyProvider verifies the tokens carrying a kid header, typically a
+	// JWKSProvider for tokens of an external identity provider
+	KeyProvider KeyProvider
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// ClockSkew tolerated between the token issuer's clock and ours
+	ClockSkew time.Duration
+	// ExpiryWarning is how long before its token expires a long-lived connection
+	// is told to re-authenticate, DefaultExpiryWarning when zero
+	ExpiryWarning time.Duration
+}
+
+var jwtConfig = JWTConfig{ClockSkew: DefaultClockSkew}
+
+// ConfigureJWT sets the configuration used by every authentication entry point.
+// It must be called at startup, before serving requests.
+func ConfigureJWT(cfg JWTConfig) {
+	jwtConfig = cfg
+}
+
+// LoadJWTConfigFromEnv reads the JWT configuration from the environment:
+//   - JWT_HS256_SECRET: shared secret of HS256 tokens
+//   - JWT_PUBLIC_KEY_FILE: PEM file holding the RSA or ECDSA public key (or certificate)
+//   - JWT_JWKS: JWKS document (file path or URL) of the keys selected by kid
+//   - JWT_JWKS_REFRESH_INTERVAL: minimum delay between two JWKS reloads (1m by default)
+//   - JWT_ISSUER, JWT_AUDIENCE: expected iss and aud claims
+//   - JWT_CLOCK_SKEW: tolerated clock skew, as a Go duration (30s by default)
+//   - JWT_EXPIRY_WARNING: delay before expiry at which long-lived connections
+//     are told to re-authenticate, as a Go duration (1m by default)
+func LoadJWTConfigFromEnv() (JWTConfig, error) {
+	cfg := JWTConfig{
+		HMACSecret:    []byte(os.Getenv("JWT_HS256_SECRET")),
+		Issuer:        os.Getenv("JWT_ISSUER"),
+		Audience:      os.Getenv("JWT_AUDIENCE"),
+		ClockSkew:     DefaultClockSkew,
+		ExpiryWarning: DefaultExpiryWarning,
+	}
+
+	if path := os.Getenv("JWT_PUBLIC_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return JWTConfig{}, fmt.Errorf("reading JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+		if cfg.PublicKey, err = ParsePublicKeyPEM(data); err != nil {
+			return JWTConfig{}, fmt.Errorf("parsing JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+	}
+
+	if source := os.Getenv("JWT_JWKS"); source != "" {
+		refreshInterval := DefaultJWKSRefreshInterval
+		if interval := os.Getenv("JWT_JWKS_REFRESH_INTERVAL"); interval != "" {
+			d, err := time.ParseDuration(interval)
+			if err != nil {
+				return JWTConfig{}, fmt.Errorf("parsing JWT_JWKS_REFRESH_INTERVAL: %w", err)
+			}
+			refreshInterval = d
+		}
+		provider, err := NewJWKSProvider(source, refreshInterval)
+		if err != nil {
+			return JWTConfig{}, fmt.Errorf("loading JWT_JWKS: %w", err)
+		}
+		cfg.KeyProvider = provider
+	}
+
+	if skew := os.Getenv("JWT_CLOCK_SKEW"); skew != "" {
+		d, err := time.ParseDuration(skew)
+		if err != nil {
+			return JWTConfig{}, fmt.Errorf("parsing JWT_CLOCK_SKEW: %w", err)
+		}
+		cfg.ClockSkew = d
+	}
+
+	if warning := os.Getenv("JWT_EXPIRY_WARNING"); warning != "" {
+		d, err := time.ParseDuration(warning)
+		if err != nil {
+			return JWTConfig{}, fmt.Errorf("parsing JWT_EXPIRY_WARNING: %w", err)
+		}
+		cfg.ExpiryWarning = d
+	}
+
+	if len(cfg.HMACSecret) == 0 && cfg.PublicKey == nil && cfg.KeyProvider == nil {
+		return JWTConfig{}, fmt.Errorf("no JWT key configured, set JWT_HS256_SECRET, JWT_PUBLIC_KEY_FILE and/or JWT_JWKS")
+	}
+	return cfg, nil
+}
+
+// ParsePublicKeyPEM parses an RSA or ECDSA public key, or the one of a certificate
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key crypto.PublicKey
+	switch {
+	case block.Type == "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case strings.HasSuffix(block.Type, "RSA PUBLIC KEY"):
+		rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = rsaKey
+	default:
+		pkixKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = pkixKey
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
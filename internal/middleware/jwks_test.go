@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func base64BigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": base64BigInt(key.N),
+		"e": base64BigInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "use": "sig", "alg": "ES256", "crv": "P-256",
+		"x": base64BigInt(key.X),
+		"y": base64BigInt(key.Y),
+	}
+}
+
+// writeJWKS writes a JWKS document holding the given keys to path
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, validClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWKSProvider_LocalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &testRSAKey.PublicKey), ecJWK("ec-1", &testECKey.PublicKey))
+
+	provider, err := NewJWKSProvider(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJWTConfig(t, JWTConfig{KeyProvider: provider, ClockSkew: DefaultClockSkew})
+
+	tokens := map[string]string{
+		"RS256": signWithKid(t, jwt.SigningMethodRS256, "rsa-1", testRSAKey),
+		"ES256": signWithKid(t, jwt.SigningMethodES256, "ec-1", testECKey),
+	}
+	for name, token := range tokens {
+		t.Run(name, func(t *testing.T) {
+			claims, err := SocketIOJWTAuth(map[string]interface{}{"token": token})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if claims.UserID != "user-1" {
+				t.Errorf("Expected user-1, got %s", claims.UserID)
+			}
+		})
+	}
+}
+
+func TestJWKSProvider_KeyTypeMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &testRSAKey.PublicKey))
+
+	provider, err := NewJWKSProvider(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJWTConfig(t, JWTConfig{KeyProvider: provider, ClockSkew: DefaultClockSkew})
+
+	// An ES256 token must not be verified with the RSA key of its kid
+	token := signWithKid(t, jwt.SigningMethodES256, "rsa-1", testECKey)
+	if _, err := verifyJWT(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected %v, got %v", ErrTokenInvalid, err)
+	}
+}
+
+func TestJWKSProvider_RotationRefresh(t *testing.T) {
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &testRSAKey.PublicKey))
+
+	provider, err := NewJWKSProvider(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJWTConfig(t, JWTConfig{KeyProvider: provider, ClockSkew: DefaultClockSkew})
+
+	// The identity provider rotates its key: the unknown kid triggers a reload
+	writeJWKS(t, path, rsaJWK("rsa-2", &rotatedKey.PublicKey))
+	if _, err := verifyJWT(signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotatedKey)); err != nil {
+		t.Errorf("Expected the rotated key to be loaded, got %v", err)
+	}
+	if _, err := verifyJWT(signWithKid(t, jwt.SigningMethodRS256, "rsa-1", testRSAKey)); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected the retired key to be rejected, got %v", err)
+	}
+}
+
+func TestJWKSProvider_RefreshRateLimited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &testRSAKey.PublicKey))
+
+	provider, err := NewJWKSProvider(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeJWKS(t, path, rsaJWK("rsa-2", &testRSAKey.PublicKey))
+	if _, err := provider.Key("rsa-2"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected %v before the refresh interval elapsed, got %v", ErrUnknownKeyID, err)
+	}
+	if _, err := provider.Key("rsa-1"); err != nil {
+		t.Errorf("Expected the cached key to still be served, got %v", err)
+	}
+}
+
+func TestJWKSProvider_URL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{ecJWK("ec-1", &testECKey.PublicKey)},
+		})
+	}))
+	defer server.Close()
+
+	provider, err := NewJWKSProvider(server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := provider.Key("ec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testECKey.PublicKey.Equal(key) {
+		t.Error("Expected the served EC key")
+	}
+}
@@ -2,86 +2,239 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zishang520/socket.io/v2/socket"
 )
 
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Exp    int64  `json:"exp"`
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
 }
 
-// JWTAuth creates a JWT authentication middleware for HTTP requests
+// Token verification errors, telling apart tokens to refresh from garbage
+var (
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenMalformed = errors.New("token malformed")
+	ErrTokenInvalid   = errors.New("token invalid")
+	ErrTokenRevoked   = errors.New("token revoked")
+)
+
+// JWTAuth creates a JWT authentication middleware for HTTP requests.
+// Requests may authenticate with an API key instead, see ConfigureAPIKeys.
 func JWTAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if key, ok := apiKeyFromRequest(c); ok {
+			return apiKeyAuth(c, key)
+		}
+
 		// Get the Authorization header
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Authorization header required",
-			})
+			return unauthorized(c, AuthMethodJWT, "Authorization header required", nil)
 		}
 
 		// Check if it's a Bearer token
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid authorization header format",
-			})
+			return unauthorized(c, AuthMethodJWT, "Invalid authorization header format", nil)
 		}
 
 		// Extract the token
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Token is required",
-			})
+			return unauthorized(c, AuthMethodJWT, "Token is required", nil)
 		}
 
-		// Verify the JWT token (currently returns true as requested)
+		// Verify the JWT token
 		claims, err := verifyJWT(token)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token",
-			})
+			return unauthorized(c, AuthMethodJWT, tokenErrorMessage(err), err)
 		}
 
 		// Set user information in context
-		c.Locals("user_id", claims.UserID)
-		c.Locals("user_email", claims.Email)
+		authenticated(c, PrincipalFromClaims(claims))
 
 		return c.Next()
 	}
 }
 
-// verifyJWT verifies the JWT token and returns claims
-// For now, this always returns true as requested
+// verifyJWT verifies the signature (HS256, RS256 or ES256) and the registered
+// claims of the token against the configured keys, and returns its claims.
+// Errors wrap ErrTokenExpired, ErrTokenMalformed, ErrTokenInvalid or ErrTokenRevoked.
 func verifyJWT(token string) (*JWTClaims, error) {
-	// TODO: Implement actual JWT verification when ready
-	// For now, return dummy claims to satisfy the interface
-	log.Println("🚀 VERIFY JWT")
-	return &JWTClaims{
-		UserID: "dummy-user-id",
-		Email:  "dummy@example.com",
-		Exp:    999999999999, // Far future expiry
-	}, nil
+	cfg := jwtConfig
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+
+	claims := &JWTClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return verificationKey(cfg, t)
+	}, options...)
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return nil, fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+
+	// Identity providers put the user id in the standard sub claim
+	if claims.UserID == "" {
+		claims.UserID = claims.Subject
+	}
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("%w: no user_id nor sub claim", ErrTokenInvalid)
+	}
+	if revocations != nil && claims.ID != "" && revocations.IsRevoked(claims.ID) {
+		return nil, fmt.Errorf("%w: jti %s", ErrTokenRevoked, claims.ID)
+	}
+	if revocations != nil {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		if revocations.IsSubjectRevoked(claims.UserID, issuedAt) {
+			return nil, fmt.Errorf("%w: tokens of user %s", ErrTokenRevoked, claims.UserID)
+		}
+	}
+	return claims, nil
+}
+
+// VerifyToken verifies a bearer token like the authentication middlewares do
+func VerifyToken(token string) (*JWTClaims, error) {
+	return verifyJWT(token)
+}
+
+// verificationKey returns the configured key matching the token algorithm.
+// Tokens carrying a kid header are verified with the key provider's key.
+func verificationKey(cfg JWTConfig, token *jwt.Token) (interface{}, error) {
+	if kid, ok := token.Header["kid"].(string); ok && cfg.KeyProvider != nil {
+		key, err := cfg.KeyProvider.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if !keyMatchesMethod(key, token.Method) {
+			return nil, fmt.Errorf("key %q can't verify %s tokens", kid, token.Method.Alg())
+		}
+		return key, nil
+	}
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(cfg.HMACSecret) > 0 {
+			return cfg.HMACSecret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if key, ok := cfg.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if key, ok := cfg.PublicKey.(*ecdsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no key configured for %s tokens", token.Method.Alg())
+}
+
+// keyMatchesMethod prevents a key from being used with another algorithm family,
+// e.g. an RSA public key as an HMAC secret
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// tokenErrorMessage is the error answered to clients whose token was rejected
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return "Token expired"
+	case errors.Is(err, ErrTokenMalformed):
+		return "Malformed token"
+	case errors.Is(err, ErrTokenRevoked):
+		return "Token revoked"
+	default:
+		return "Invalid token"
+	}
+}
+
+// authenticated attaches the principal to the request. Only the authentication
+// of WebSocket upgrades is recorded: one event per request would flood the
+// audit log with successes.
+func authenticated(c *fiber.Ctx, p *Principal) {
+	setPrincipal(c, p)
+	if websocket.IsWebSocketUpgrade(c) {
+		RecordRequestAuth(c, AuthEvent{Method: p.Method, Success: true, Principal: p})
+	}
+}
+
+// unauthorized answers a request whose authentication failed with message,
+// recording err (or message without it) as the reason
+func unauthorized(c *fiber.Ctx, method AuthMethod, message string, err error) error {
+	reason := message
+	if err != nil {
+		reason = err.Error()
+	}
+	RecordRequestAuth(c, AuthEvent{Method: method, Reason: reason})
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": message,
+	})
 }
 
 // GetUserIDFromContext extracts user ID from Fiber context
 func GetUserIDFromContext(c *fiber.Ctx) string {
-	if userID, ok := c.Locals("user_id").(string); ok {
-		return userID
+	if p := GetPrincipal(c); p != nil {
+		return p.ID
+	}
+	return ""
+}
+
+// GetTokenIDFromContext extracts the jti of the request's token from Fiber context
+func GetTokenIDFromContext(c *fiber.Ctx) string {
+	if p := GetPrincipal(c); p != nil && p.Method == AuthMethodJWT {
+		return p.TokenID
 	}
 	return ""
 }
 
 // GetUserEmailFromContext extracts user email from Fiber context
 func GetUserEmailFromContext(c *fiber.Ctx) string {
-	if email, ok := c.Locals("user_email").(string); ok {
-		return email
+	if p := GetPrincipal(c); p != nil {
+		return p.Email
 	}
 	return ""
 }
@@ -102,22 +255,17 @@ func WSJWTAuth(c *fiber.Ctx) error {
 	}
 
 	if token == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Token is required for WebSocket connection",
-		})
+		return unauthorized(c, AuthMethodJWT, "Token is required for WebSocket connection", nil)
 	}
 
 	// Verify the JWT token
 	claims, err := verifyJWT(token)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid token",
-		})
+		return unauthorized(c, AuthMethodJWT, tokenErrorMessage(err), err)
 	}
 
 	// Set user information in context for WebSocket connection
-	c.Locals("user_id", claims.UserID)
-	c.Locals("user_email", claims.Email)
+	authenticated(c, PrincipalFromClaims(claims))
 
 	return c.Next()
 }
@@ -151,25 +299,57 @@ func SocketIOJWTAuth(handshakeAuth map[string]interface{}) (*JWTClaims, error) {
 	return verifyJWT(token)
 }
 
+// SocketIOAuthenticate authenticates a Socket.IO connection with the token of
+// its handshake and checks that its principal is granted permission, when not
+// empty. The decision is recorded and the principal attached to the client.
+func SocketIOAuthenticate(client *socket.Socket, permission string) (*Principal, error) {
+	event := SocketIOAuthEvent(client)
+	event.Method = AuthMethodJWT
+
+	authData, ok := client.Handshake().Auth.(map[string]interface{})
+	if !ok {
+		event.Reason = "invalid auth data format"
+		RecordAuthEvent(event)
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid auth data format")
+	}
+	claims, err := SocketIOJWTAuth(authData)
+	if err != nil {
+		event.Reason = err.Error()
+		RecordAuthEvent(event)
+		return nil, err
+	}
+
+	event.Principal = PrincipalFromClaims(claims)
+	if permission != "" {
+		if err := SocketIOAuthorize(event.Principal, permission); err != nil {
+			event.Reason = fmt.Sprintf("permission %s required", permission)
+			RecordAuthEvent(event)
+			return nil, err
+		}
+	}
+	event.Success = true
+	RecordAuthEvent(event)
+	SetSocketIOPrincipal(client, event.Principal)
+	return event.Principal, nil
+}
+
 // WithUserContext adds user information to a context
 func WithUserContext(ctx context.Context, userID, email string) context.Context {
-	ctx = context.WithValue(ctx, "user_id", userID)
-	ctx = context.WithValue(ctx, "user_email", email)
-	return ctx
+	return WithPrincipal(ctx, &Principal{ID: userID, Email: email})
 }
 
 // GetUserIDFromGoContext extracts user ID from Go context
 func GetUserIDFromGoContext(ctx context.Context) string {
-	if userID, ok := ctx.Value("user_id").(string); ok {
-		return userID
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.ID
 	}
 	return ""
 }
 
 // GetUserEmailFromGoContext extracts user email from Go context
 func GetUserEmailFromGoContext(ctx context.Context) string {
-	if email, ok := ctx.Value("user_email").(string); ok {
-		return email
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.Email
 	}
 	return ""
 }
@@ -1,12 +1,83 @@
 package middleware
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// Signing fixtures shared by the tests
+var (
+	testSecret = []byte("test-hs256-secret")
+	testRSAKey *rsa.PrivateKey
+	testECKey  *ecdsa.PrivateKey
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testECKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		panic(err)
+	}
+	ConfigureJWT(JWTConfig{HMACSecret: testSecret, ClockSkew: DefaultClockSkew})
+	os.Exit(m.Run())
+}
+
+// useJWTConfig swaps the JWT configuration for the duration of the test
+func useJWTConfig(t *testing.T, cfg JWTConfig) {
+	previous := jwtConfig
+	ConfigureJWT(cfg)
+	t.Cleanup(func() { ConfigureJWT(previous) })
+}
+
+func validClaims() *JWTClaims {
+	now := time.Now()
+	return &JWTClaims{
+		UserID: "user-1",
+		Email:  "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims *JWTClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signHS256(t *testing.T, claims *JWTClaims) string {
+	return signToken(t, jwt.SigningMethodHS256, testSecret, claims)
+}
+
+func errorMessage(t *testing.T, body io.Reader) string {
+	t.Helper()
+	var payload map[string]string
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload["error"]
+}
+
 func TestJWTAuth_MissingHeader(t *testing.T) {
 	app := fiber.New()
 	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
@@ -55,37 +126,179 @@ func TestJWTAuth_ValidToken(t *testing.T) {
 	})
 
 	req := httptest.NewRequest("GET", "/test", nil)
-	req.Header.Set("Authorization", "Bearer valid-jwt-token")
+	req.Header.Set("Authorization", "Bearer "+signHS256(t, validClaims()))
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Since verifyJWT always returns true, this should succeed
 	if resp.StatusCode != fiber.StatusOK {
-		t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["user_id"] != "user-1" || body["user_email"] != "user@example.com" {
+		t.Errorf("Expected the token identity in context, got %v", body)
+	}
+}
+
+func TestJWTAuth_ExpiredToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "success"})
+	})
+
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+signHS256(t, claims))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+	if msg := errorMessage(t, resp.Body); msg != "Token expired" {
+		t.Errorf("Expected 'Token expired', got %q", msg)
+	}
+}
+
+func TestJWTAuth_MalformedToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "success"})
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer valid-jwt-token")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+	if msg := errorMessage(t, resp.Body); msg != "Malformed token" {
+		t.Errorf("Expected 'Malformed token', got %q", msg)
+	}
+}
+
+func TestVerifyJWT_SigningMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    JWTConfig
+		method jwt.SigningMethod
+		key    interface{}
+	}{
+		{"HS256", JWTConfig{HMACSecret: testSecret}, jwt.SigningMethodHS256, testSecret},
+		{"RS256", JWTConfig{PublicKey: &testRSAKey.PublicKey}, jwt.SigningMethodRS256, testRSAKey},
+		{"ES256", JWTConfig{PublicKey: &testECKey.PublicKey}, jwt.SigningMethodES256, testECKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useJWTConfig(t, tt.cfg)
+
+			claims, err := verifyJWT(signToken(t, tt.method, tt.key, validClaims()))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if claims.UserID != "user-1" {
+				t.Errorf("Expected user-1, got %s", claims.UserID)
+			}
+			if claims.Email != "user@example.com" {
+				t.Errorf("Expected user@example.com, got %s", claims.Email)
+			}
+		})
 	}
 }
 
-func TestVerifyJWT_AlwaysTrue(t *testing.T) {
-	// Test that verifyJWT currently always returns true as requested
-	claims, err := verifyJWT("any-token")
+func TestVerifyJWT_Rejected(t *testing.T) {
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatal(err)
+	}
+	useJWTConfig(t, JWTConfig{
+		HMACSecret: testSecret,
+		PublicKey:  &testRSAKey.PublicKey,
+		Issuer:     "https://auth.cognyx.io",
+		Audience:   "psychic-robot",
+		ClockSkew:  30 * time.Second,
+	})
+
+	withClaims := func(mutate func(c *JWTClaims)) *JWTClaims {
+		claims := validClaims()
+		claims.Issuer = "https://auth.cognyx.io"
+		claims.Audience = jwt.ClaimStrings{"psychic-robot"}
+		mutate(claims)
+		return claims
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"not a JWT", "valid-jwt-token", ErrTokenMalformed},
+		{"wrong HS256 secret", signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), withClaims(func(c *JWTClaims) {})), ErrTokenInvalid},
+		{"wrong RS256 key", signToken(t, jwt.SigningMethodRS256, otherRSAKey, withClaims(func(c *JWTClaims) {})), ErrTokenInvalid},
+		{"unconfigured ES256 key", signToken(t, jwt.SigningMethodES256, testECKey, withClaims(func(c *JWTClaims) {})), ErrTokenInvalid},
+		{"alg none", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, withClaims(func(c *JWTClaims) {})), ErrTokenInvalid},
+		{"expired", signHS256(t, withClaims(func(c *JWTClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) })), ErrTokenExpired},
+		{"no expiry", signHS256(t, withClaims(func(c *JWTClaims) { c.ExpiresAt = nil })), ErrTokenInvalid},
+		{"not yet valid", signHS256(t, withClaims(func(c *JWTClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) })), ErrTokenInvalid},
+		{"issued in the future", signHS256(t, withClaims(func(c *JWTClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) })), ErrTokenInvalid},
+		{"wrong issuer", signHS256(t, withClaims(func(c *JWTClaims) { c.Issuer = "https://evil.example.com" })), ErrTokenInvalid},
+		{"wrong audience", signHS256(t, withClaims(func(c *JWTClaims) { c.Audience = jwt.ClaimStrings{"other-api"} })), ErrTokenInvalid},
+		{"no subject", signHS256(t, withClaims(func(c *JWTClaims) { c.UserID = "" })), ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
 	}
+}
+
+func TestVerifyJWT_ClockSkew(t *testing.T) {
+	now := time.Now()
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+	claims.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second))
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(10 * time.Second))
 
-	if claims.UserID != "dummy-user-id" {
-		t.Errorf("Expected dummy-user-id, got %s", claims.UserID)
+	if _, err := verifyJWT(signHS256(t, claims)); err != nil {
+		t.Errorf("Expected claims within the clock skew to be accepted, got %v", err)
 	}
+}
 
-	if claims.Email != "dummy@example.com" {
-		t.Errorf("Expected dummy@example.com, got %s", claims.Email)
+func TestVerifyJWT_SubjectFallback(t *testing.T) {
+	claims := validClaims()
+	claims.UserID = ""
+	claims.Subject = "user-from-sub"
+
+	verified, err := verifyJWT(signHS256(t, claims))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verified.UserID != "user-from-sub" {
+		t.Errorf("Expected user-from-sub, got %s", verified.UserID)
 	}
 }
 
 func TestSocketIOJWTAuth_MissingToken(t *testing.T) {
 	authData := map[string]interface{}{}
-	
+
 	_, err := SocketIOJWTAuth(authData)
 	if err == nil {
 		t.Error("Expected error for missing token, got nil")
@@ -94,30 +307,40 @@ func TestSocketIOJWTAuth_MissingToken(t *testing.T) {
 
 func TestSocketIOJWTAuth_WithToken(t *testing.T) {
 	authData := map[string]interface{}{
-		"token": "valid-jwt-token",
+		"token": signHS256(t, validClaims()),
 	}
-	
+
 	claims, err := SocketIOJWTAuth(authData)
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatalf("Expected no error, got %v", err)
 	}
 
-	if claims.UserID != "dummy-user-id" {
-		t.Errorf("Expected dummy-user-id, got %s", claims.UserID)
+	if claims.UserID != "user-1" {
+		t.Errorf("Expected user-1, got %s", claims.UserID)
 	}
 }
 
 func TestSocketIOJWTAuth_WithAuthorization(t *testing.T) {
 	authData := map[string]interface{}{
-		"authorization": "Bearer valid-jwt-token",
+		"authorization": "Bearer " + signHS256(t, validClaims()),
 	}
-	
+
 	claims, err := SocketIOJWTAuth(authData)
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if claims.UserID != "user-1" {
+		t.Errorf("Expected user-1, got %s", claims.UserID)
+	}
+}
+
+func TestSocketIOJWTAuth_InvalidToken(t *testing.T) {
+	authData := map[string]interface{}{
+		"token": "valid-jwt-token",
 	}
 
-	if claims.UserID != "dummy-user-id" {
-		t.Errorf("Expected dummy-user-id, got %s", claims.UserID)
+	if _, err := SocketIOJWTAuth(authData); !errors.Is(err, ErrTokenMalformed) {
+		t.Errorf("Expected %v, got %v", ErrTokenMalformed, err)
 	}
-}
\ No newline at end of file
+}
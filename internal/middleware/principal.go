@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// AuthMethod is how a principal authenticated
+type AuthMethod string
+
+// Authentication methods
+const (
+	AuthMethodJWT    AuthMethod = "jwt"
+	AuthMethodAPIKey AuthMethod = "api_key"
+)
+
+// Principal is the authenticated caller, whichever the transport (HTTP,
+// WebSocket, Socket.IO) and the authentication method
+type Principal struct {
+	// ID is the user id, or APIKeyUserID for API keys
+	ID    string
+	Email string
+	Roles []string
+	// Scopes are the permissions of the API key the caller authenticated with
+	Scopes   []string
+	TenantID string
+	Method   AuthMethod
+	// TokenID is the jti of the token, or the id of the API key
+	TokenID  string
+	Issuer   string
+	IssuedAt time.Time
+	// ExpiresAt is zero when the credentials never expire
+	ExpiresAt time.Time
+}
+
+// PrincipalFromClaims returns the principal authenticated by verified JWT claims
+func PrincipalFromClaims(claims *JWTClaims) *Principal {
+	p := &Principal{
+		ID:       claims.UserID,
+		Email:    claims.Email,
+		Roles:    claims.Roles,
+		TenantID: claims.TenantID,
+		Method:   AuthMethodJWT,
+		TokenID:  claims.ID,
+		Issuer:   claims.Issuer,
+	}
+	if claims.IssuedAt != nil {
+		p.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		p.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return p
+}
+
+// Can reports whether the principal's roles or scopes grant permission.
+// A nil principal is granted nothing.
+func (p *Principal) Can(permission string) bool {
+	return p != nil && Allowed(p.Roles, p.Scopes, permission)
+}
+
+// principalKey is the context.Context and Fiber locals key of the principal
+type principalKey struct{}
+
+// wsPrincipalLocal carries the principal to WebSocket handlers: the websocket
+// middleware only copies the string-keyed locals of the upgrade request
+const wsPrincipalLocal = "principal"
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, or nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// setPrincipal attaches the principal to the request, for handlers and
+// for the context.Context they get from c.UserContext()
+func setPrincipal(c *fiber.Ctx, p *Principal) {
+	c.Locals(principalKey{}, p)
+	c.Locals(wsPrincipalLocal, p)
+	c.SetUserContext(WithPrincipal(c.UserContext(), p))
+}
+
+// GetPrincipal returns the principal authenticated by JWTAuth or WSJWTAuth, or nil
+func GetPrincipal(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals(principalKey{}).(*Principal)
+	return p
+}
+
+// GetWSPrincipal returns the principal of a connection upgraded after WSJWTAuth, or nil
+func GetWSPrincipal(c *websocket.Conn) *Principal {
+	p, _ := c.Locals(wsPrincipalLocal).(*Principal)
+	return p
+}
+
+// SetSocketIOPrincipal attaches the principal to an authenticated Socket.IO connection
+func SetSocketIOPrincipal(client *socket.Socket, p *Principal) {
+	client.SetData(p)
+}
+
+// GetSocketIOPrincipal returns the principal of a Socket.IO connection, or nil.
+// client is a *socket.Socket, or anything holding the data it was given.
+func GetSocketIOPrincipal(client interface{ Data() any }) *Principal {
+	p, _ := client.Data().(*Principal)
+	return p
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestJWTAuthAttachesPrincipal(t *testing.T) {
+	useJWTConfig(t, JWTConfig{HMACSecret: testSecret})
+	claims := validClaims()
+	claims.ID = "jti-1"
+	claims.Roles = []string{"user"}
+	claims.TenantID = "tenant-1"
+
+	var fromLocals, fromContext *Principal
+	app := fiber.New()
+	app.Get("/", JWTAuth(), func(c *fiber.Ctx) error {
+		fromLocals = GetPrincipal(c)
+		fromContext = PrincipalFromContext(c.UserContext())
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signHS256(t, claims))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	if fromLocals == nil || fromLocals != fromContext {
+		t.Fatalf("Expected the same principal in locals and context, got %v and %v", fromLocals, fromContext)
+	}
+	p := fromLocals
+	if p.ID != "user-1" || p.Email != "user@example.com" || p.TenantID != "tenant-1" {
+		t.Errorf("Expected the identity of the claims, got %+v", p)
+	}
+	if p.Method != AuthMethodJWT || p.TokenID != "jti-1" || p.ExpiresAt.IsZero() {
+		t.Errorf("Expected the token metadata of the claims, got %+v", p)
+	}
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	if p := PrincipalFromContext(context.Background()); p != nil {
+		t.Errorf("Expected no principal, got %+v", p)
+	}
+
+	ctx := WithUserContext(context.Background(), "user-1", "user@example.com")
+	if GetUserIDFromGoContext(ctx) != "user-1" || GetUserEmailFromGoContext(ctx) != "user@example.com" {
+		t.Errorf("Expected the user of the context, got %+v", PrincipalFromContext(ctx))
+	}
+
+	// String keys don't collide with the typed key
+	ctx = context.WithValue(context.Background(), "user_id", "user-2")
+	if id := GetUserIDFromGoContext(ctx); id != "" {
+		t.Errorf("Expected no user, got %s", id)
+	}
+}
+
+func TestNilPrincipalHasNoPermission(t *testing.T) {
+	useRoles(t, RolePermissions{"admin": {"*"}})
+
+	var p *Principal
+	if p.Can("users:read") {
+		t.Errorf("Expected a nil principal to be denied")
+	}
+	if !(&Principal{Roles: []string{"admin"}}).Can("users:read") {
+		t.Errorf("Expected an admin to be allowed")
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RolePermissions maps each role to the permissions it grants. A permission
+// is "<resource>:<action>" (e.g. "users:write"); "<resource>:*" grants every
+// action on the resource and "*" grants everything.
+type RolePermissions map[string][]string
+
+// DefaultRolePermissions is used when no role configuration is provided
+var DefaultRolePermissions = RolePermissions{
+	"admin": {"*"},
+	"user":  {"users:read", "users:write", "realtime:connect"},
+}
+
+var rolePermissions = DefaultRolePermissions
+
+// ConfigureRoles sets the role to permission mapping used by RequirePermission
+// and the WebSocket / Socket.IO checks. It must be called at startup.
+func ConfigureRoles(permissions RolePermissions) {
+	rolePermissions = permissions
+}
+
+// LoadRolePermissionsFromEnv reads the JSON file named by RBAC_CONFIG_FILE,
+// e.g. {"admin": ["*"], "support": ["users:read"]}.
+// DefaultRolePermissions is returned when the variable is not set.
+func LoadRolePermissionsFromEnv() (RolePermissions, error) {
+	path := os.Getenv("RBAC_CONFIG_FILE")
+	if path == "" {
+		return DefaultRolePermissions, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading RBAC_CONFIG_FILE: %w", err)
+	}
+	var permissions RolePermissions
+	if err := json.Unmarshal(data, &permissions); err != nil {
+		return nil, fmt.Errorf("parsing RBAC_CONFIG_FILE: %w", err)
+	}
+	return permissions, nil
+}
+
+// HasRole reports whether roles holds one of the wanted roles
+func HasRole(roles []string, wanted ...string) bool {
+	for _, role := range wanted {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether one of the roles, or one of the API key scopes, grants permission
+func Allowed(roles, scopes []string, permission string) bool {
+	for _, scope := range scopes {
+		if permissionMatches(scope, permission) {
+			return true
+		}
+	}
+	return HasPermission(roles, permission)
+}
+
+// HasPermission reports whether one of the roles grants permission
+func HasPermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if permissionMatches(granted, permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func permissionMatches(granted, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(granted, "*")
+	return ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(permission, prefix)
+}
+
+// RequireRole only lets through the requests authenticated with one of the roles.
+// It must run after JWTAuth or WSJWTAuth.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !HasRole(GetUserRolesFromContext(c), roles...) {
+			return forbidden(c, fmt.Sprintf("role %s required", strings.Join(roles, " or ")))
+		}
+		return c.Next()
+	}
+}
+
+// RequirePermission only lets through the requests whose roles (or API key
+// scopes) grant permission. It must run after JWTAuth or WSJWTAuth.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !GetPrincipal(c).Can(permission) {
+			return forbidden(c, fmt.Sprintf("permission %s required", permission))
+		}
+		return c.Next()
+	}
+}
+
+// SocketIOAuthorize checks that the principal of an authenticated Socket.IO
+// connection is granted permission
+func SocketIOAuthorize(p *Principal, permission string) error {
+	if !p.Can(permission) {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Permission %s required", permission))
+	}
+	return nil
+}
+
+// forbidden answers a request its principal isn't authorized for, recording the refusal
+func forbidden(c *fiber.Ctx, reason string) error {
+	event := AuthEvent{Principal: GetPrincipal(c), Reason: reason}
+	if event.Principal != nil {
+		event.Method = event.Principal.Method
+	}
+	RecordRequestAuth(c, event)
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "Insufficient permissions",
+	})
+}
+
+// GetUserRolesFromContext extracts user roles from Fiber context
+func GetUserRolesFromContext(c *fiber.Ctx) []string {
+	if p := GetPrincipal(c); p != nil {
+		return p.Roles
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// useRoles swaps the role configuration for the duration of the test
+func useRoles(t *testing.T, permissions RolePermissions) {
+	previous := rolePermissions
+	ConfigureRoles(permissions)
+	t.Cleanup(func() { ConfigureRoles(previous) })
+}
+
+func TestHasPermission(t *testing.T) {
+	useRoles(t, RolePermissions{
+		"admin":   {"*"},
+		"support": {"users:*"},
+		"viewer":  {"users:read"},
+	})
+
+	tests := []struct {
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{[]string{"admin"}, "apikeys:write", true},
+		{[]string{"support"}, "users:write", true},
+		{[]string{"support"}, "usersettings:write", false},
+		{[]string{"viewer"}, "users:read", true},
+		{[]string{"viewer"}, "users:write", false},
+		{[]string{"viewer", "support"}, "users:write", true},
+		{[]string{"unknown"}, "users:read", false},
+		{nil, "users:read", false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.roles, tt.permission); got != tt.want {
+			t.Errorf("Expected HasPermission(%v, %s) = %v, got %v", tt.roles, tt.permission, tt.want, got)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	useRoles(t, RolePermissions{"viewer": {"users:read"}})
+
+	app := fiber.New()
+	app.Get("/users", JWTAuth(), RequirePermission("users:read"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Post("/users", JWTAuth(), RequirePermission("users:write"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	claims := validClaims()
+	claims.Roles = []string{"viewer"}
+	token := signHS256(t, claims)
+
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{"GET", fiber.StatusOK},
+		{"POST", fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("Expected status %d for %s, got %d", tt.want, tt.method, resp.StatusCode)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app := fiber.New()
+	app.Get("/admin", JWTAuth(), RequireRole("admin"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for _, roles := range [][]string{{"user"}, {"user", "admin"}} {
+		claims := validClaims()
+		claims.Roles = roles
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256(t, claims))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := fiber.StatusForbidden
+		if HasRole(roles, "admin") {
+			want = fiber.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("Expected status %d for roles %v, got %d", want, roles, resp.StatusCode)
+		}
+	}
+}
+
+func TestSocketIOAuthorize(t *testing.T) {
+	useRoles(t, RolePermissions{"user": {"realtime:connect"}})
+
+	claims := validClaims()
+	if err := SocketIOAuthorize(PrincipalFromClaims(claims), "realtime:connect"); err == nil {
+		t.Errorf("Expected a connection without roles to be refused")
+	}
+	claims.Roles = []string{"user"}
+	if err := SocketIOAuthorize(PrincipalFromClaims(claims), "realtime:connect"); err != nil {
+		t.Errorf("Expected the connection to be authorized, got %v", err)
+	}
+}
+
+func TestLoadRolePermissionsFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.json")
+	if err := os.WriteFile(path, []byte(`{"support": ["users:read"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RBAC_CONFIG_FILE", path)
+
+	permissions, err := LoadRolePermissionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || len(permissions["support"]) != 1 || permissions["support"][0] != "users:read" {
+		t.Errorf("Expected the support role only, got %v", permissions)
+	}
+
+	t.Setenv("RBAC_CONFIG_FILE", "")
+	if permissions, _ := LoadRolePermissionsFromEnv(); permissions["admin"] == nil {
+		t.Errorf("Expected the default roles, got %v", permissions)
+	}
+}
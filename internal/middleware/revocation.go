@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRevocationRefreshInterval is the delay between two reloads of the
+// revocation list, picking up the tokens revoked by other server instances
+const DefaultRevocationRefreshInterval = time.Minute
+
+// revocationTTL is how long a revocation is kept when the lifetime of the
+// tokens it rejects is unknown
+const revocationTTL = 24 * time.Hour
+
+// RevocationStore persists the revoked tokens, e.g. the revoked_tokens table
+type RevocationStore interface {
+	Revoke(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	// ActiveRevocations returns the expiry of the revoked tokens not expired yet, by jti
+	ActiveRevocations(ctx context.Context) (map[string]time.Time, error)
+	// RevokeSubject revokes the tokens of the user issued up to revokedAt
+	RevokeSubject(ctx context.Context, userID string, revokedAt, expiresAt time.Time) error
+	// ActiveSubjectRevocations returns the time up to which the tokens of the
+	// revoked users are rejected, by user
+	ActiveSubjectRevocations(ctx context.Context) (map[string]time.Time, error)
+	PurgeExpired(ctx context.Context) (int64, error)
+}
+
+// Revocations is the list of access tokens revoked before their expiry, by jti,
+// and of the users whose tokens were all revoked. It is persisted in a
+// RevocationStore and cached in memory, so checking a token costs no query.
+// Live connections authenticated with a token are tracked so that revoking it
+// disconnects them.
+type Revocations struct {
+	store RevocationStore
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+	// subjects holds the time up to which the tokens of each revoked user are rejected
+	subjects    map[string]time.Time
+	connections map[string]map[*connection]struct{}
+}
+
+// connection is a live connection authenticated with a token
+type connection struct {
+	userID   string
+	issuedAt time.Time
+	close    func()
+}
+
+var revocations *Revocations
+
+// ConfigureRevocations sets the revocation list checked by every authentication
+// entry point. It must be called at startup; without it no token is revoked.
+func ConfigureRevocations(r *Revocations) {
+	revocations = r
+}
+
+// NewRevocations creates a revocation list and loads it from store
+func NewRevocations(ctx context.Context, store RevocationStore) (*Revocations, error) {
+	r := &Revocations{
+		store:       store,
+		revoked:     map[string]time.Time{},
+		subjects:    map[string]time.Time{},
+		connections: map[string]map[*connection]struct{}{},
+	}
+	if err := r.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// IsRevoked reports whether the token with this jti was revoked
+func (r *Revocations) IsRevoked(jti string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.revoked[jti]
+	return ok
+}
+
+// IsSubjectRevoked reports whether the tokens of the user issued at issuedAt
+// were revoked. Tokens without iat are rejected once their user is revoked,
+// as are the ones issued in the second of the revocation.
+func (r *Revocations) IsSubjectRevoked(userID string, issuedAt time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cutoff, ok := r.subjects[userID]
+	return ok && !issuedAt.After(cutoff)
+}
+
+// Revoke revokes the token and disconnects the connections authenticated with it.
+// Tokens without jti can't be revoked.
+func (r *Revocations) Revoke(ctx context.Context, claims *JWTClaims) error {
+	if claims.ID == "" {
+		return fmt.Errorf("token of user %s has no jti", claims.UserID)
+	}
+	expiresAt := time.Now().Add(revocationTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := r.store.Revoke(ctx, claims.ID, claims.UserID, expiresAt); err != nil {
+		return err
+	}
+	r.revoke(claims.ID, expiresAt)
+	return nil
+}
+
+// RevokeSubject revokes every token of the user issued until now and
+// disconnects the connections authenticated with them. Tokens issued later,
+// e.g. on the next login, are accepted.
+func (r *Revocations) RevokeSubject(ctx context.Context, userID string) error {
+	now := time.Now()
+	if err := r.store.RevokeSubject(ctx, userID, now, now.Add(revocationTTL)); err != nil {
+		return err
+	}
+	r.revokeSubject(userID, now)
+	return nil
+}
+
+// Refresh reloads the revocation list from the store, disconnecting the
+// connections of the tokens revoked elsewhere since the last reload
+func (r *Revocations) Refresh(ctx context.Context) error {
+	active, err := r.store.ActiveRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	subjects, err := r.store.ActiveSubjectRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	for jti, expiresAt := range active {
+		r.revoke(jti, expiresAt)
+	}
+	for userID, revokedAt := range subjects {
+		r.revokeSubject(userID, revokedAt)
+	}
+
+	// Expired tokens are rejected anyway
+	r.mu.Lock()
+	for jti := range r.revoked {
+		if _, ok := active[jti]; !ok {
+			delete(r.revoked, jti)
+		}
+	}
+	for userID := range r.subjects {
+		if _, ok := subjects[userID]; !ok {
+			delete(r.subjects, userID)
+		}
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the revocation list and purges the expired revocations every
+// interval, until ctx is done
+func (r *Revocations) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if purged, err := r.store.PurgeExpired(ctx); err != nil {
+			log.Printf("Revoked tokens purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("🧹 Purged %d expired revoked tokens", purged)
+		}
+		if err := r.Refresh(ctx); err != nil {
+			log.Printf("Revocation list refresh failed: %v", err)
+		}
+	}
+}
+
+// Track registers a live connection authenticated as the principal: close is
+// called when its token gets revoked. The returned function unregisters it and
+// must be called once the connection is closed.
+func (r *Revocations) Track(p *Principal, close func()) (untrack func()) {
+	jti := p.TokenID
+	conn := &connection{userID: p.ID, issuedAt: p.IssuedAt, close: close}
+
+	r.mu.Lock()
+	if r.connections[jti] == nil {
+		r.connections[jti] = map[*connection]struct{}{}
+	}
+	r.connections[jti][conn] = struct{}{}
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.connections[jti], conn)
+		if len(r.connections[jti]) == 0 {
+			delete(r.connections, jti)
+		}
+	}
+}
+
+// revoke caches a revocation and closes the connections of the token
+func (r *Revocations) revoke(jti string, expiresAt time.Time) {
+	r.mu.Lock()
+	r.revoked[jti] = expiresAt
+	var closing []*connection
+	for conn := range r.connections[jti] {
+		closing = append(closing, conn)
+	}
+	delete(r.connections, jti)
+	r.mu.Unlock()
+
+	// Outside of the lock: closing a connection untracks it
+	for _, conn := range closing {
+		conn.close()
+	}
+	if len(closing) > 0 {
+		log.Printf("🔒 Token %s revoked, closed %d live connections", jti, len(closing))
+	}
+}
+
+// revokeSubject caches the revocation of the user's tokens issued up to
+// revokedAt and closes the connections authenticated with them
+func (r *Revocations) revokeSubject(userID string, revokedAt time.Time) {
+	r.mu.Lock()
+	if revokedAt.After(r.subjects[userID]) {
+		r.subjects[userID] = revokedAt
+	}
+	cutoff := r.subjects[userID]
+	var closing []*connection
+	for jti, conns := range r.connections {
+		for conn := range conns {
+			if conn.userID == userID && !conn.issuedAt.After(cutoff) {
+				closing = append(closing, conn)
+				delete(conns, conn)
+			}
+		}
+		if len(conns) == 0 {
+			delete(r.connections, jti)
+		}
+	}
+	r.mu.Unlock()
+
+	// Outside of the lock: closing a connection untracks it
+	for _, conn := range closing {
+		conn.close()
+	}
+	if len(closing) > 0 {
+		log.Printf("🔒 Tokens of user %s revoked, closed %d live connections", userID, len(closing))
+	}
+}
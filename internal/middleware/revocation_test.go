@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memoryRevocationStore is an in-memory RevocationStore
+type memoryRevocationStore struct {
+	mu       sync.Mutex
+	revoked  map[string]time.Time
+	subjects map[string]time.Time
+}
+
+func (s *memoryRevocationStore) Revoke(_ context.Context, jti, _ string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryRevocationStore) ActiveRevocations(context.Context) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := map[string]time.Time{}
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.After(time.Now()) {
+			active[jti] = expiresAt
+		}
+	}
+	return active, nil
+}
+
+func (s *memoryRevocationStore) RevokeSubject(_ context.Context, userID string, revokedAt, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subjects == nil {
+		s.subjects = map[string]time.Time{}
+	}
+	s.subjects[userID] = revokedAt
+	return nil
+}
+
+func (s *memoryRevocationStore) ActiveSubjectRevocations(context.Context) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := map[string]time.Time{}
+	for userID, revokedAt := range s.subjects {
+		active[userID] = revokedAt
+	}
+	return active, nil
+}
+
+func (s *memoryRevocationStore) PurgeExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+
+// useRevocations configures a revocation list backed by store for the duration of the test
+func useRevocations(t *testing.T, store *memoryRevocationStore) *Revocations {
+	r, err := NewRevocations(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := revocations
+	ConfigureRevocations(r)
+	t.Cleanup(func() { ConfigureRevocations(previous) })
+	return r
+}
+
+func TestRevokedTokenRejected(t *testing.T) {
+	r := useRevocations(t, &memoryRevocationStore{revoked: map[string]time.Time{}})
+
+	app := fiber.New()
+	app.Get("/test", JWTAuth(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	claims := validClaims()
+	claims.ID = "jti-1"
+	token := signHS256(t, claims)
+
+	if _, err := SocketIOJWTAuth(map[string]interface{}{"token": token}); err != nil {
+		t.Fatalf("Expected the token to be valid before revocation, got %v", err)
+	}
+	if err := r.Revoke(context.Background(), claims); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+	if msg := errorMessage(t, resp.Body); msg != "Token revoked" {
+		t.Errorf("Expected 'Token revoked', got %q", msg)
+	}
+	if _, err := SocketIOJWTAuth(map[string]interface{}{"token": token}); err == nil {
+		t.Errorf("Expected the Socket.IO authentication to reject the revoked token")
+	}
+}
+
+func TestRevokeClosesTrackedConnections(t *testing.T) {
+	r := useRevocations(t, &memoryRevocationStore{revoked: map[string]time.Time{}})
+
+	closed := map[string]int{}
+	r.Track(&Principal{ID: "user-1", TokenID: "jti-1"}, func() { closed["jti-1"]++ })
+	r.Track(&Principal{ID: "user-1", TokenID: "jti-1"}, func() { closed["jti-1"]++ })
+	untrack := r.Track(&Principal{ID: "user-1", TokenID: "jti-2"}, func() { closed["jti-2"]++ })
+	untrack()
+
+	claims := validClaims()
+	for _, jti := range []string{"jti-1", "jti-2"} {
+		claims.ID = jti
+		if err := r.Revoke(context.Background(), claims); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if closed["jti-1"] != 2 {
+		t.Errorf("Expected both connections of the revoked token closed, got %d", closed["jti-1"])
+	}
+	if closed["jti-2"] != 0 {
+		t.Errorf("Expected an untracked connection to stay open, got %d closes", closed["jti-2"])
+	}
+}
+
+func TestRefreshPicksUpOtherInstancesRevocations(t *testing.T) {
+	store := &memoryRevocationStore{revoked: map[string]time.Time{}}
+	r := useRevocations(t, store)
+
+	closed := false
+	r.Track(&Principal{ID: "user-1", TokenID: "jti-1"}, func() { closed = true })
+
+	// Revoked by another server instance
+	store.Revoke(context.Background(), "jti-1", "user-1", time.Now().Add(time.Hour))
+	if r.IsRevoked("jti-1") {
+		t.Fatalf("Expected the revocation to be unknown before the refresh")
+	}
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.IsRevoked("jti-1") {
+		t.Errorf("Expected the token revoked after the refresh")
+	}
+	if !closed {
+		t.Errorf("Expected the connection of the token closed")
+	}
+}
+
+func TestRevokeWithoutJTI(t *testing.T) {
+	r := useRevocations(t, &memoryRevocationStore{revoked: map[string]time.Time{}})
+
+	if err := r.Revoke(context.Background(), validClaims()); err == nil {
+		t.Errorf("Expected an error for a token without jti")
+	}
+}
+
+func TestRevokeSubject(t *testing.T) {
+	r := useRevocations(t, &memoryRevocationStore{revoked: map[string]time.Time{}})
+
+	claims := validClaims()
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	token := signHS256(t, claims)
+
+	closed := map[string]bool{}
+	r.Track(&Principal{ID: claims.UserID, TokenID: "jti-1", IssuedAt: claims.IssuedAt.Time}, func() { closed["user"] = true })
+	r.Track(&Principal{ID: "someone-else", TokenID: "jti-2", IssuedAt: claims.IssuedAt.Time}, func() { closed["other"] = true })
+	// Logged in again after the revocation
+	r.Track(&Principal{ID: claims.UserID, TokenID: "jti-3", IssuedAt: time.Now().Add(time.Minute)}, func() { closed["later"] = true })
+
+	if err := r.RevokeSubject(context.Background(), claims.UserID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected the token of the user to be revoked, got %v", err)
+	}
+	if r.IsSubjectRevoked(claims.UserID, time.Now().Add(time.Minute)) {
+		t.Errorf("Expected the tokens issued after the revocation to be accepted")
+	}
+	if !closed["user"] || closed["other"] || closed["later"] {
+		t.Errorf("Expected only the connection of the revoked token closed, got %v", closed)
+	}
+}
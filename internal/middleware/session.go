@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultExpiryWarning is how long before its token expires a long-lived
+// connection is told to re-authenticate
+const DefaultExpiryWarning = time.Minute
+
+// SessionHooks are the callbacks through which a Session acts on its connection
+type SessionHooks struct {
+	// Expiring is called when the token is about to expire: the client should
+	// re-authenticate with a fresh token before expiresAt
+	Expiring func(expiresAt time.Time)
+	// Closed is called once, when the token expired or got revoked without being
+	// replaced. The connection must then be closed.
+	Closed func(reason error)
+}
+
+// Session follows the token a long-lived connection (Socket.IO, WebSocket) was
+// authenticated with. Handshake checks alone would leave the connection
+// authenticated forever: the session warns the client before the token
+// expires, accepts a fresh token through Reauth, and closes the connection
+// once the token expired or got revoked.
+type Session struct {
+	permission string
+	hooks      SessionHooks
+
+	mu          sync.Mutex
+	principal   *Principal
+	generation  int
+	warnTimer   *time.Timer
+	expireTimer *time.Timer
+	untrack     func()
+	closed      bool
+}
+
+// NewSession starts following the token the principal of a connection
+// authenticated with. Tokens given to Reauth must grant permission, when not empty.
+func NewSession(p *Principal, permission string, hooks SessionHooks) *Session {
+	s := &Session{permission: permission, hooks: hooks}
+	s.mu.Lock()
+	s.watch(p)
+	s.mu.Unlock()
+	return s
+}
+
+// Principal returns the principal authenticated by the session's current token
+func (s *Session) Principal() *Principal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal
+}
+
+// Reauth replaces the session's token with a fresh one of the same user,
+// postponing its expiry
+func (s *Session) Reauth(token string) (*Principal, error) {
+	claims, err := verifyJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	p := PrincipalFromClaims(claims)
+	if s.permission != "" && !p.Can(s.permission) {
+		return nil, fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Permission %s required", s.permission))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("%w: session closed", ErrTokenExpired)
+	}
+	if p.ID != s.principal.ID {
+		return nil, fmt.Errorf("%w: token of user %s, session of user %s", ErrTokenInvalid, p.ID, s.principal.ID)
+	}
+	s.watch(p)
+	return p, nil
+}
+
+// Close stops following the token, once the connection is closed
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	s.closed = true
+}
+
+// watch schedules the expiry warning and the expiry of the token, and closes the
+// session if the token gets revoked. It must be called with the lock held.
+func (s *Session) watch(p *Principal) {
+	s.stop()
+	s.principal = p
+	s.generation++
+	generation := s.generation
+
+	if revocations != nil {
+		s.untrack = revocations.Track(p, func() { s.expire(generation, ErrTokenRevoked) })
+	}
+	if p.ExpiresAt.IsZero() {
+		return
+	}
+
+	expiresAt := p.ExpiresAt
+	warning := jwtConfig.ExpiryWarning
+	if warning <= 0 {
+		warning = DefaultExpiryWarning
+	}
+	s.warnTimer = time.AfterFunc(max(time.Until(expiresAt.Add(-warning)), 0), func() {
+		s.mu.Lock()
+		current := s.generation == generation && !s.closed
+		s.mu.Unlock()
+		if current && s.hooks.Expiring != nil {
+			s.hooks.Expiring(expiresAt)
+		}
+	})
+	s.expireTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		s.expire(generation, ErrTokenExpired)
+	})
+}
+
+// expire closes the session, unless its token was replaced in the meantime
+func (s *Session) expire(generation int, reason error) {
+	s.mu.Lock()
+	if s.generation != generation || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.stop()
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.hooks.Closed != nil {
+		s.hooks.Closed(reason)
+	}
+}
+
+// stop cancels the timers and the revocation tracking of the current token
+func (s *Session) stop() {
+	if s.warnTimer != nil {
+		s.warnTimer.Stop()
+	}
+	if s.expireTimer != nil {
+		s.expireTimer.Stop()
+	}
+	if s.untrack != nil {
+		s.untrack()
+		s.untrack = nil
+	}
+}
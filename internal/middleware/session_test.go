@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sessionEvents records the hooks called by a session
+type sessionEvents struct {
+	expiring chan time.Time
+	closed   chan error
+}
+
+func newSessionEvents() (*sessionEvents, SessionHooks) {
+	events := &sessionEvents{expiring: make(chan time.Time, 4), closed: make(chan error, 4)}
+	return events, SessionHooks{
+		Expiring: func(expiresAt time.Time) { events.expiring <- expiresAt },
+		Closed:   func(reason error) { events.closed <- reason },
+	}
+}
+
+func expiringClaims(ttl time.Duration) *JWTClaims {
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl))
+	return claims
+}
+
+func TestSessionWarnsThenCloses(t *testing.T) {
+	useJWTConfig(t, JWTConfig{HMACSecret: testSecret, ExpiryWarning: time.Hour})
+	events, hooks := newSessionEvents()
+
+	s := NewSession(PrincipalFromClaims(expiringClaims(time.Second)), "", hooks)
+	defer s.Close()
+
+	select {
+	case <-events.expiring:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a token-expiring warning")
+	}
+	select {
+	case reason := <-events.closed:
+		if !errors.Is(reason, ErrTokenExpired) {
+			t.Errorf("Expected the session closed for expiry, got %v", reason)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected the session closed once the token expired")
+	}
+}
+
+func TestSessionReauthPostponesExpiry(t *testing.T) {
+	useJWTConfig(t, JWTConfig{HMACSecret: testSecret, ClockSkew: DefaultClockSkew})
+	events, hooks := newSessionEvents()
+
+	s := NewSession(PrincipalFromClaims(expiringClaims(time.Second)), "", hooks)
+	defer s.Close()
+
+	fresh := expiringClaims(time.Hour)
+	fresh.ID = "fresh"
+	if _, err := s.Reauth(signHS256(t, fresh)); err != nil {
+		t.Fatalf("Expected the reauth to succeed, got %v", err)
+	}
+	if s.Principal().TokenID != "fresh" {
+		t.Errorf("Expected the session to follow the fresh token, got %q", s.Principal().TokenID)
+	}
+
+	select {
+	case reason := <-events.closed:
+		t.Errorf("Expected the session to outlive the first token, closed: %v", reason)
+	case <-time.After(2 * time.Second):
+	}
+}
+
+func TestSessionReauthRejections(t *testing.T) {
+	useRoles(t, RolePermissions{"user": {"realtime:connect"}})
+	_, hooks := newSessionEvents()
+
+	claims := validClaims()
+	claims.Roles = []string{"user"}
+	principal := PrincipalFromClaims(claims)
+	s := NewSession(principal, "realtime:connect", hooks)
+	defer s.Close()
+
+	otherUser := validClaims()
+	otherUser.UserID = "user-2"
+	otherUser.Roles = []string{"user"}
+	noPermission := validClaims()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"garbage", "not-a-token"},
+		{"other user", signHS256(t, otherUser)},
+		{"missing permission", signHS256(t, noPermission)},
+	}
+	for _, tt := range tests {
+		if _, err := s.Reauth(tt.token); err == nil {
+			t.Errorf("Expected the %s reauth to fail", tt.name)
+		}
+	}
+	if s.Principal() != principal {
+		t.Errorf("Expected the session to keep its token after failed reauths")
+	}
+}
+
+func TestSessionClosedOnRevocation(t *testing.T) {
+	r := useRevocations(t, &memoryRevocationStore{revoked: map[string]time.Time{}})
+	events, hooks := newSessionEvents()
+
+	claims := validClaims()
+	claims.ID = "jti-1"
+	s := NewSession(PrincipalFromClaims(claims), "", hooks)
+	defer s.Close()
+
+	if err := r.Revoke(context.Background(), claims); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-events.closed:
+		if !errors.Is(reason, ErrTokenRevoked) {
+			t.Errorf("Expected the session closed for revocation, got %v", reason)
+		}
+	default:
+		t.Errorf("Expected the session closed by the revocation")
+	}
+}
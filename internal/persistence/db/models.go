@@ -6,13 +6,89 @@ package db
 
 import (
 	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID         string             `json:"id"`
+	Name       string             `json:"name"`
+	KeyPrefix  string             `json:"key_prefix"`
+	KeyHash    string             `json:"key_hash"`
+	Scopes     []string           `json:"scopes"`
+	CreatedBy  string             `json:"created_by"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type AuthEvent struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Transport   string    `json:"transport"`
+	Method      string    `json:"method"`
+	Success     bool      `json:"success"`
+	PrincipalID string    `json:"principal_id"`
+	Email       string    `json:"email"`
+	TenantID    string    `json:"tenant_id"`
+	TokenID     string    `json:"token_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent"`
+	Reason      string    `json:"reason"`
+}
+
+type RefreshToken struct {
+	ID        int64              `json:"id"`
+	UserID    string             `json:"user_id"`
+	TokenHash string             `json:"token_hash"`
+	FamilyID  string             `json:"family_id"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	CreatedAt time.Time          `json:"created_at"`
+	RevokedAt pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type RevokedSubject struct {
+	UserID    string    `json:"user_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RevokedToken struct {
+	Jti       string    `json:"jti"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
 type User struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Roles     []string  `json:"roles"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           string             `json:"id"`
+	Name         string             `json:"name"`
+	Email        string             `json:"email"`
+	Roles        []string           `json:"roles"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+	Deleted      bool               `json:"deleted"`
+	DeletedAt    pgtype.Timestamptz `json:"deleted_at"`
+	Revision     int32              `json:"revision"`
+	RevHash      string             `json:"rev_hash"`
+	PasswordHash string             `json:"password_hash"`
+}
+
+type Version struct {
+	ID         int64     `json:"id"`
+	ObjectType string    `json:"object_type"`
+	ObjectID   string    `json:"object_id"`
+	Version    int32     `json:"version"`
+	Json       []byte    `json:"json"`
+	Kind       string    `json:"kind"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type VersionCounter struct {
+	ObjectType  string `json:"object_type"`
+	ObjectID    string `json:"object_id"`
+	LastVersion int32  `json:"last_version"`
 }
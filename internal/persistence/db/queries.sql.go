@@ -7,12 +7,126 @@ package db
 
 import (
 	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, name, key_prefix, key_hash, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, name, key_prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at
+`
+
+type CreateAPIKeyParams struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	KeyPrefix string             `json:"key_prefix"`
+	KeyHash   string             `json:"key_hash"`
+	Scopes    []string           `json:"scopes"`
+	CreatedBy string             `json:"created_by"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, createAPIKey,
+		arg.ID,
+		arg.Name,
+		arg.KeyPrefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.CreatedBy,
+		arg.ExpiresAt,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyPrefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const createAuthEvent = `-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (created_at, transport, method, success, principal_id, email, tenant_id, token_id, remote_addr, user_agent, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`
+
+type CreateAuthEventParams struct {
+	CreatedAt   time.Time `json:"created_at"`
+	Transport   string    `json:"transport"`
+	Method      string    `json:"method"`
+	Success     bool      `json:"success"`
+	PrincipalID string    `json:"principal_id"`
+	Email       string    `json:"email"`
+	TenantID    string    `json:"tenant_id"`
+	TokenID     string    `json:"token_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent"`
+	Reason      string    `json:"reason"`
+}
+
+func (q *Queries) CreateAuthEvent(ctx context.Context, arg CreateAuthEventParams) error {
+	_, err := q.db.Exec(ctx, createAuthEvent,
+		arg.CreatedAt,
+		arg.Transport,
+		arg.Method,
+		arg.Success,
+		arg.PrincipalID,
+		arg.Email,
+		arg.TenantID,
+		arg.TokenID,
+		arg.RemoteAddr,
+		arg.UserAgent,
+		arg.Reason,
+	)
+	return err
+}
+
+const createRefreshToken = `-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, token_hash, family_id, expires_at, created_at, revoked_at
+`
+
+type CreateRefreshTokenParams struct {
+	UserID    string    `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	FamilyID  string    `json:"family_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, createRefreshToken,
+		arg.UserID,
+		arg.TokenHash,
+		arg.FamilyID,
+		arg.ExpiresAt,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, name, email, roles)
 VALUES ($1, $2, $3, $4)
-RETURNING id, name, email, roles, created_at, updated_at
+RETURNING id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash
 `
 
 type CreateUserParams struct {
@@ -37,22 +151,150 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Roles,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
 	)
 	return i, err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users
+const createVersion = `-- name: CreateVersion :one
+WITH counter AS (
+    INSERT INTO version_counters (object_type, object_id, last_version)
+    VALUES ($1::varchar, $2::varchar, 1)
+    ON CONFLICT (object_type, object_id) DO UPDATE
+    SET last_version = version_counters.last_version + 1
+    RETURNING last_version
+)
+INSERT INTO version (object_type, object_id, version, json, kind, action, actor)
+SELECT $1::varchar, $2::varchar, counter.last_version,
+       $3::jsonb, $4::varchar, $5::varchar, $6::varchar
+FROM counter
+RETURNING id, object_type, object_id, version, json, kind, action, actor, created_at
+`
+
+type CreateVersionParams struct {
+	ObjectType string `json:"object_type"`
+	ObjectID   string `json:"object_id"`
+	Json       []byte `json:"json"`
+	Kind       string `json:"kind"`
+	Action     string `json:"action"`
+	Actor      string `json:"actor"`
+}
+
+// The version is numbered from the counter of the object, which stays locked
+// until the transaction ends, so concurrent changes can't get the same number
+func (q *Queries) CreateVersion(ctx context.Context, arg CreateVersionParams) (Version, error) {
+	row := q.db.QueryRow(ctx, createVersion,
+		arg.ObjectType,
+		arg.ObjectID,
+		arg.Json,
+		arg.Kind,
+		arg.Action,
+		arg.Actor,
+	)
+	var i Version
+	err := row.Scan(
+		&i.ID,
+		&i.ObjectType,
+		&i.ObjectID,
+		&i.Version,
+		&i.Json,
+		&i.Kind,
+		&i.Action,
+		&i.Actor,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteUser = `-- name: DeleteUser :one
+UPDATE users
+SET deleted = TRUE,
+    deleted_at = clock_timestamp(),
+    updated_at = clock_timestamp()
 WHERE id = $1
+RETURNING id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
-	return err
+func (q *Queries) DeleteUser(ctx context.Context, id string) (User, error) {
+	row := q.db.QueryRow(ctx, deleteUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Roles,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const ensureVersionPartitions = `-- name: EnsureVersionPartitions :one
+SELECT ensure_version_partitions($1::integer)::integer
+`
+
+func (q *Queries) EnsureVersionPartitions(ctx context.Context, monthsAhead int32) (int32, error) {
+	row := q.db.QueryRow(ctx, ensureVersionPartitions, monthsAhead)
+	var ensure_version_partitions int32
+	err := row.Scan(&ensure_version_partitions)
+	return ensure_version_partitions, err
+}
+
+const getAPIKeyByHash = `-- name: GetAPIKeyByHash :one
+SELECT id, name, key_prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at FROM api_keys
+WHERE key_hash = $1
+`
+
+func (q *Queries) GetAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyPrefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getRefreshTokenByHashForUpdate = `-- name: GetRefreshTokenByHashForUpdate :one
+SELECT id, user_id, token_hash, family_id, expires_at, created_at, revoked_at FROM refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetRefreshTokenByHashForUpdate(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, getRefreshTokenByHashForUpdate, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, name, email, roles, created_at, updated_at FROM users
+SELECT id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash FROM users
 WHERE email = $1
 `
 
@@ -66,12 +308,17 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Roles,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, roles, created_at, updated_at FROM users
+SELECT id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash FROM users
 WHERE id = $1
 `
 
@@ -85,12 +332,255 @@ func (q *Queries) GetUserByID(ctx context.Context, id string) (User, error) {
 		&i.Roles,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const getUserByIDForUpdate = `-- name: GetUserByIDForUpdate :one
+SELECT id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash FROM users
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetUserByIDForUpdate(ctx context.Context, id string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByIDForUpdate, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Roles,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
 	)
 	return i, err
 }
 
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, name, key_prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at FROM api_keys
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAPIKeys(ctx context.Context) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.KeyPrefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthEvents = `-- name: ListAuthEvents :many
+SELECT id, created_at, transport, method, success, principal_id, email, tenant_id, token_id, remote_addr, user_agent, reason FROM auth_events
+WHERE ($1::varchar IS NULL OR transport = $1)
+  AND ($2::varchar IS NULL OR method = $2)
+  AND ($3::boolean IS NULL OR success = $3)
+  AND ($4::varchar IS NULL OR principal_id = $4)
+  AND ($5::varchar IS NULL OR email = $5)
+  AND ($6::varchar IS NULL OR remote_addr = $6)
+  AND ($7::timestamptz IS NULL OR created_at >= $7)
+  AND ($8::timestamptz IS NULL OR created_at < $8)
+  AND ($9::bigint IS NULL OR id < $9)
+ORDER BY id DESC
+LIMIT $10
+`
+
+type ListAuthEventsParams struct {
+	Transport   pgtype.Text        `json:"transport"`
+	Method      pgtype.Text        `json:"method"`
+	Success     pgtype.Bool        `json:"success"`
+	PrincipalID pgtype.Text        `json:"principal_id"`
+	Email       pgtype.Text        `json:"email"`
+	RemoteAddr  pgtype.Text        `json:"remote_addr"`
+	Since       pgtype.Timestamptz `json:"since"`
+	Until       pgtype.Timestamptz `json:"until"`
+	BeforeID    pgtype.Int8        `json:"before_id"`
+	RowLimit    int32              `json:"row_limit"`
+}
+
+func (q *Queries) ListAuthEvents(ctx context.Context, arg ListAuthEventsParams) ([]AuthEvent, error) {
+	rows, err := q.db.Query(ctx, listAuthEvents,
+		arg.Transport,
+		arg.Method,
+		arg.Success,
+		arg.PrincipalID,
+		arg.Email,
+		arg.RemoteAddr,
+		arg.Since,
+		arg.Until,
+		arg.BeforeID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuthEvent
+	for rows.Next() {
+		var i AuthEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Transport,
+			&i.Method,
+			&i.Success,
+			&i.PrincipalID,
+			&i.Email,
+			&i.TenantID,
+			&i.TokenID,
+			&i.RemoteAddr,
+			&i.UserAgent,
+			&i.Reason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActiveRevokedSubjects = `-- name: ListActiveRevokedSubjects :many
+SELECT user_id, revoked_at, expires_at FROM revoked_subjects
+WHERE expires_at > NOW()
+`
+
+func (q *Queries) ListActiveRevokedSubjects(ctx context.Context) ([]RevokedSubject, error) {
+	rows, err := q.db.Query(ctx, listActiveRevokedSubjects)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RevokedSubject
+	for rows.Next() {
+		var i RevokedSubject
+		if err := rows.Scan(&i.UserID, &i.RevokedAt, &i.ExpiresAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActiveRevokedTokens = `-- name: ListActiveRevokedTokens :many
+SELECT jti, user_id, expires_at, revoked_at FROM revoked_tokens
+WHERE expires_at > NOW()
+`
+
+func (q *Queries) ListActiveRevokedTokens(ctx context.Context) ([]RevokedToken, error) {
+	rows, err := q.db.Query(ctx, listActiveRevokedTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RevokedToken
+	for rows.Next() {
+		var i RevokedToken
+		if err := rows.Scan(
+			&i.Jti,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPatchesAfter = `-- name: ListPatchesAfter :many
+SELECT id, object_type, object_id, version, created_at FROM (
+    SELECT DISTINCT ON (object_type, object_id) id, object_type, object_id, version, kind, created_at
+    FROM version
+    WHERE created_at >= $1
+      AND (object_type, object_id) IN (
+        SELECT object_type, object_id FROM version WHERE created_at < $1
+      )
+    ORDER BY object_type, object_id, version
+) AS first_versions
+WHERE kind = 'patch'
+`
+
+type ListPatchesAfterRow struct {
+	ID         int64     `json:"id"`
+	ObjectType string    `json:"object_type"`
+	ObjectID   string    `json:"object_id"`
+	Version    int32     `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// The first version from before on of the objects having older versions,
+// when stored as a patch: it can't be rebuilt once the older ones are archived
+func (q *Queries) ListPatchesAfter(ctx context.Context, before time.Time) ([]ListPatchesAfterRow, error) {
+	rows, err := q.db.Query(ctx, listPatchesAfter, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPatchesAfterRow
+	for rows.Next() {
+		var i ListPatchesAfterRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectType,
+			&i.ObjectID,
+			&i.Version,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUsers = `-- name: ListUsers :many
-SELECT id, name, email, roles, created_at, updated_at FROM users
+SELECT id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash FROM users
+WHERE NOT deleted
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2
 `
@@ -116,6 +606,11 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 			&i.Roles,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Deleted,
+			&i.DeletedAt,
+			&i.Revision,
+			&i.RevHash,
+			&i.PasswordHash,
 		); err != nil {
 			return nil, err
 		}
@@ -127,14 +622,425 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 	return items, nil
 }
 
+const listUsersSinceCheckpoint = `-- name: ListUsersSinceCheckpoint :many
+SELECT id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash FROM users
+WHERE (updated_at, id) > ($1::timestamptz, $2::varchar)
+ORDER BY updated_at ASC, id ASC
+LIMIT $3
+`
+
+type ListUsersSinceCheckpointParams struct {
+	MinUpdatedAt time.Time `json:"min_updated_at"`
+	MinID        string    `json:"min_id"`
+	RowLimit     int32     `json:"row_limit"`
+}
+
+func (q *Queries) ListUsersSinceCheckpoint(ctx context.Context, arg ListUsersSinceCheckpointParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsersSinceCheckpoint, arg.MinUpdatedAt, arg.MinID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.Roles,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Deleted,
+			&i.DeletedAt,
+			&i.Revision,
+			&i.RevHash,
+			&i.PasswordHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVersionChain = `-- name: ListVersionChain :many
+SELECT id, object_type, object_id, version, json, kind, action, actor, created_at FROM version
+WHERE object_id = $1
+  AND object_type = $2
+  AND version <= $3
+  AND version >= (
+    SELECT COALESCE(MAX(s.version), 0) FROM version s
+    WHERE s.object_id = $1
+      AND s.object_type = $2
+      AND s.version <= $3
+      AND s.kind = 'snapshot'
+  )
+ORDER BY version
+`
+
+type ListVersionChainParams struct {
+	ObjectID   string `json:"object_id"`
+	ObjectType string `json:"object_type"`
+	Upto       int32  `json:"upto"`
+}
+
+// The versions rebuilding version upto: its closest snapshot and the patches
+// after it, oldest first
+func (q *Queries) ListVersionChain(ctx context.Context, arg ListVersionChainParams) ([]Version, error) {
+	rows, err := q.db.Query(ctx, listVersionChain, arg.ObjectID, arg.ObjectType, arg.Upto)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectType,
+			&i.ObjectID,
+			&i.Version,
+			&i.Json,
+			&i.Kind,
+			&i.Action,
+			&i.Actor,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVersionPartitions = `-- name: ListVersionPartitions :many
+SELECT c.relname::text AS name
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = 'version'::regclass
+ORDER BY c.relname
+`
+
+// The monthly partitions of version, oldest first
+func (q *Queries) ListVersionPartitions(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, listVersionPartitions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVersions = `-- name: ListVersions :many
+SELECT id, object_type, object_id, version, action, actor, created_at FROM version
+WHERE object_id = $1
+  AND object_type = $2
+  AND ($3::integer IS NULL OR version < $3)
+  AND ($4::varchar IS NULL OR action = $4)
+  AND ($5::varchar IS NULL OR actor = $5)
+  AND ($6::timestamptz IS NULL OR created_at >= $6)
+  AND ($7::timestamptz IS NULL OR created_at < $7)
+ORDER BY version DESC
+LIMIT $8
+`
+
+type ListVersionsParams struct {
+	ObjectID      string             `json:"object_id"`
+	ObjectType    string             `json:"object_type"`
+	BeforeVersion pgtype.Int4        `json:"before_version"`
+	Action        pgtype.Text        `json:"action"`
+	Actor         pgtype.Text        `json:"actor"`
+	Since         pgtype.Timestamptz `json:"since"`
+	Until         pgtype.Timestamptz `json:"until"`
+	RowLimit      int32              `json:"row_limit"`
+}
+
+type ListVersionsRow struct {
+	ID         int64     `json:"id"`
+	ObjectType string    `json:"object_type"`
+	ObjectID   string    `json:"object_id"`
+	Version    int32     `json:"version"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Newest first, walking idx_version_object_type_version_desc
+func (q *Queries) ListVersions(ctx context.Context, arg ListVersionsParams) ([]ListVersionsRow, error) {
+	rows, err := q.db.Query(ctx, listVersions,
+		arg.ObjectID,
+		arg.ObjectType,
+		arg.BeforeVersion,
+		arg.Action,
+		arg.Actor,
+		arg.Since,
+		arg.Until,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListVersionsRow
+	for rows.Next() {
+		var i ListVersionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectType,
+			&i.ObjectID,
+			&i.Version,
+			&i.Action,
+			&i.Actor,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockVersionWrites = `-- name: LockVersionWrites :exec
+SELECT pg_advisory_xact_lock_shared(hashtext('version'))
+`
+
+// Taken by every version write until its transaction ends, so that versions
+// aren't written while LockVersions is held
+func (q *Queries) LockVersionWrites(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockVersionWrites)
+	return err
+}
+
+const lockVersions = `-- name: LockVersions :exec
+SELECT pg_advisory_xact_lock(hashtext('version'))
+`
+
+// Blocks the version writes until the transaction ends
+func (q *Queries) LockVersions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockVersions)
+	return err
+}
+
+const purgeAuthEvents = `-- name: PurgeAuthEvents :execrows
+DELETE FROM auth_events
+WHERE created_at < $1::timestamptz
+`
+
+func (q *Queries) PurgeAuthEvents(ctx context.Context, createdBefore time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeAuthEvents, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deleted
+  AND deleted_at < $1::timestamptz
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedUsers, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredRevokedSubjects = `-- name: PurgeExpiredRevokedSubjects :execrows
+DELETE FROM revoked_subjects
+WHERE expires_at <= NOW()
+`
+
+func (q *Queries) PurgeExpiredRevokedSubjects(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredRevokedSubjects)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredRevokedTokens = `-- name: PurgeExpiredRevokedTokens :execrows
+DELETE FROM revoked_tokens
+WHERE expires_at <= NOW()
+`
+
+func (q *Queries) PurgeExpiredRevokedTokens(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredRevokedTokens)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rebaseVersion = `-- name: RebaseVersion :exec
+UPDATE version
+SET json = $3,
+    kind = 'snapshot'
+WHERE id = $1
+  AND created_at = $2
+`
+
+type RebaseVersionParams struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Json      []byte    `json:"json"`
+}
+
+// Stores a version as a snapshot, json being its full state
+func (q *Queries) RebaseVersion(ctx context.Context, arg RebaseVersionParams) error {
+	_, err := q.db.Exec(ctx, rebaseVersion, arg.ID, arg.CreatedAt, arg.Json)
+	return err
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAPIKey(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeAPIKey, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshToken(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, revokeRefreshToken, id)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := q.db.Exec(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeSubject = `-- name: RevokeSubject :exec
+INSERT INTO revoked_subjects (user_id, revoked_at, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET revoked_at = GREATEST(revoked_subjects.revoked_at, EXCLUDED.revoked_at),
+    expires_at = GREATEST(revoked_subjects.expires_at, EXCLUDED.expires_at)
+`
+
+type RevokeSubjectParams struct {
+	UserID    string    `json:"user_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) RevokeSubject(ctx context.Context, arg RevokeSubjectParams) error {
+	_, err := q.db.Exec(ctx, revokeSubject, arg.UserID, arg.RevokedAt, arg.ExpiresAt)
+	return err
+}
+
+const revokeToken = `-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (jti, user_id, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING
+`
+
+type RevokeTokenParams struct {
+	Jti       string    `json:"jti"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) RevokeToken(ctx context.Context, arg RevokeTokenParams) error {
+	_, err := q.db.Exec(ctx, revokeToken, arg.Jti, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeUserRefreshTokens, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash = $2
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID           string `json:"id"`
+	PasswordHash string `json:"password_hash"`
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, setUserPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+`
+
+func (q *Queries) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, touchAPIKey, id)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET name = $2,
     email = $3,
     roles = $4,
-    updated_at = NOW()
+    updated_at = clock_timestamp()
 WHERE id = $1
-RETURNING id, name, email, roles, created_at, updated_at
+RETURNING id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash
 `
 
 type UpdateUserParams struct {
@@ -159,6 +1065,60 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Roles,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const upsertUser = `-- name: UpsertUser :one
+INSERT INTO users (id, name, email, roles, deleted, deleted_at)
+VALUES ($1, $2, $3, $4, $5::boolean,
+        CASE WHEN $5::boolean THEN clock_timestamp() END)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name,
+    email = EXCLUDED.email,
+    roles = EXCLUDED.roles,
+    updated_at = clock_timestamp(),
+    deleted = EXCLUDED.deleted,
+    deleted_at = CASE WHEN EXCLUDED.deleted THEN COALESCE(users.deleted_at, clock_timestamp()) END
+RETURNING id, name, email, roles, created_at, updated_at, deleted, deleted_at, revision, rev_hash, password_hash
+`
+
+type UpsertUserParams struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Roles   []string `json:"roles"`
+	Deleted bool     `json:"deleted"`
+}
+
+// Writes the user, deleted or not, in one statement: a tombstone is a single
+// revision of the row
+func (q *Queries) UpsertUser(ctx context.Context, arg UpsertUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, upsertUser,
+		arg.ID,
+		arg.Name,
+		arg.Email,
+		arg.Roles,
+		arg.Deleted,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Roles,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Deleted,
+		&i.DeletedAt,
+		&i.Revision,
+		&i.RevHash,
+		&i.PasswordHash,
 	)
 	return i, err
 }
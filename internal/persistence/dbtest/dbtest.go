@@ -0,0 +1,71 @@
+// Package dbtest gives tests a Postgres database created from sqlc/models.sql
+// and sqlc/index.sql
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DSNVariable names the environment variable holding the DSN of the Postgres
+// server the tests run against. Tests needing it are skipped without it.
+const DSNVariable = "TEST_DATABASE_URL"
+
+// New returns a pool on a schema of its own, created from the sqlc schema and
+// dropped once the test ends
+func New(tb testing.TB) *pgxpool.Pool {
+	tb.Helper()
+	dsn := os.Getenv(DSNVariable)
+	if dsn == "" {
+		tb.Skipf("%s not set", DSNVariable)
+	}
+	ctx := context.Background()
+
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer admin.Close(ctx)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+pgx.Identifier{schema}.Sanitize()); err != nil {
+		tb.Fatal(err)
+	}
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		pool.Close()
+		if conn, err := pgx.Connect(ctx, dsn); err == nil {
+			conn.Exec(ctx, "DROP SCHEMA "+pgx.Identifier{schema}.Sanitize()+" CASCADE")
+			conn.Close(ctx)
+		}
+	})
+
+	_, file, _, _ := runtime.Caller(0)
+	for _, name := range []string{"models.sql", "index.sql"} {
+		sql, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "sqlc", name))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		// Without arguments, the statements of the file run in one go
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			tb.Fatalf("loading %s: %v", name, err)
+		}
+	}
+	return pool
+}
@@ -0,0 +1,5 @@
+-- Soft-deletes users, as declared in sqlc/models.sql. Fresh databases created
+-- from models.sql don't need it.
+ALTER TABLE users
+    ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN deleted_at TIMESTAMPTZ;
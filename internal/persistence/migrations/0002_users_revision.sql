@@ -0,0 +1,29 @@
+-- Stores the RxDB revision of users, as declared in sqlc/models.sql. Fresh
+-- databases created from models.sql don't need it.
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN revision INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN rev_hash character varying(32) NOT NULL DEFAULT '';
+
+-- The existing users start at revision 1, hashed like the trigger does
+UPDATE users
+SET rev_hash = md5(row(id, name, email, roles, deleted)::text);
+
+CREATE FUNCTION users_bump_revision() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        NEW.revision := OLD.revision + 1;
+    ELSE
+        NEW.revision := 1;
+    END IF;
+    NEW.rev_hash := md5(row(NEW.id, NEW.name, NEW.email, NEW.roles, NEW.deleted)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_revision
+    BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_bump_revision();
+
+COMMIT;
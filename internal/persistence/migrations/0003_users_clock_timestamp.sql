@@ -0,0 +1,3 @@
+-- Stamps users.updated_at with the time of the write statement, as declared in
+-- sqlc/models.sql. Fresh databases created from models.sql don't need it.
+ALTER TABLE users ALTER COLUMN updated_at SET DEFAULT clock_timestamp();
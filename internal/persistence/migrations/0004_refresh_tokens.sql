@@ -0,0 +1,38 @@
+-- Adds the password logins and their refresh tokens, as declared in
+-- sqlc/models.sql. Fresh databases created from models.sql don't need it.
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+
+-- Password changes keep the revision
+CREATE OR REPLACE FUNCTION users_bump_revision() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND row(NEW.name, NEW.email, NEW.roles, NEW.deleted, NEW.updated_at)
+        IS NOT DISTINCT FROM row(OLD.name, OLD.email, OLD.roles, OLD.deleted, OLD.updated_at) THEN
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' THEN
+        NEW.revision := OLD.revision + 1;
+    ELSE
+        NEW.revision := 1;
+    END IF;
+    NEW.rev_hash := md5(row(NEW.id, NEW.name, NEW.email, NEW.roles, NEW.deleted)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE refresh_tokens (
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id character varying(64) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       token_hash character(64) NOT NULL UNIQUE,
+                       family_id character varying(64) NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_refresh_tokens_family_id
+    ON refresh_tokens (family_id);
+
+COMMIT;
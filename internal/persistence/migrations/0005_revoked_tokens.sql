@@ -0,0 +1,24 @@
+-- Adds the revoked access tokens and users, as declared in sqlc/models.sql.
+-- Fresh databases created from models.sql don't need it.
+BEGIN;
+
+CREATE TABLE revoked_tokens (
+                       jti character varying(64) PRIMARY KEY,
+                       user_id character varying(64) NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL,
+                       revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE revoked_subjects (
+                       user_id character varying(64) PRIMARY KEY,
+                       revoked_at TIMESTAMPTZ NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at
+    ON revoked_tokens (expires_at);
+
+CREATE INDEX idx_revoked_subjects_expires_at
+    ON revoked_subjects (expires_at);
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Adds the API keys, as declared in sqlc/models.sql. Fresh databases created
+-- from models.sql don't need it.
+CREATE TABLE api_keys (
+                       id character varying(64) PRIMARY KEY,
+                       name character varying NOT NULL,
+                       key_prefix character varying(16) NOT NULL,
+                       key_hash character(64) NOT NULL UNIQUE,
+                       scopes TEXT[] NOT NULL DEFAULT '{}',
+                       created_by character varying(64) NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       expires_at TIMESTAMPTZ,
+                       last_used_at TIMESTAMPTZ,
+                       revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,32 @@
+-- Adds the authentication audit log, as declared in sqlc/models.sql. Fresh
+-- databases created from models.sql don't need it.
+BEGIN;
+
+CREATE TABLE auth_events (
+                       id BIGSERIAL PRIMARY KEY,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       transport character varying(16) NOT NULL,
+                       method character varying(16) NOT NULL,
+                       success BOOLEAN NOT NULL,
+                       principal_id character varying(128) NOT NULL DEFAULT '',
+                       email character varying NOT NULL DEFAULT '',
+                       tenant_id character varying(64) NOT NULL DEFAULT '',
+                       token_id character varying(64) NOT NULL DEFAULT '',
+                       remote_addr character varying(64) NOT NULL DEFAULT '',
+                       user_agent TEXT NOT NULL DEFAULT '',
+                       reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_auth_events_created_at
+    ON auth_events (created_at);
+
+CREATE INDEX idx_auth_events_remote_addr_id
+    ON auth_events (remote_addr, id);
+
+CREATE INDEX idx_auth_events_email_id
+    ON auth_events (email, id);
+
+CREATE INDEX idx_auth_events_principal_id_id
+    ON auth_events (principal_id, id);
+
+COMMIT;
@@ -0,0 +1,24 @@
+-- Creates the version table, as declared in sqlc/models.sql, or converts the
+-- one of older databases, which identified objects by BIGINT. Fresh databases
+-- created from models.sql don't need it.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS version (
+                       id BIGSERIAL PRIMARY KEY,
+                       object_type character varying(64) NOT NULL,
+                       object_id character varying(64) NOT NULL,
+                       version INTEGER NOT NULL,
+                       json JSONB NOT NULL,
+                       action character varying(16) NOT NULL,
+                       actor character varying(128) NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE version
+    ALTER COLUMN object_id TYPE character varying(64) USING object_id::text;
+
+CREATE INDEX IF NOT EXISTS idx_version_object_type_version_desc
+    ON version (object_id, object_type, version DESC)
+    INCLUDE (json);
+
+COMMIT;
@@ -0,0 +1,5 @@
+-- Stores versions as snapshots or patches, as declared in sqlc/models.sql. The
+-- existing versions are snapshots. Fresh databases created from models.sql
+-- don't need it.
+ALTER TABLE version
+    ADD COLUMN kind character varying(8) NOT NULL DEFAULT 'snapshot' CHECK (kind IN ('snapshot', 'patch'));
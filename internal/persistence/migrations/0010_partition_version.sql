@@ -0,0 +1,62 @@
+-- Partitions an existing version table by month of created_at, as declared in
+-- sqlc/models.sql. Fresh databases created from models.sql don't need it.
+-- The versions are copied in one transaction: run it during a maintenance window.
+BEGIN;
+
+LOCK TABLE version IN ACCESS EXCLUSIVE MODE;
+
+ALTER TABLE version RENAME TO version_unpartitioned;
+ALTER INDEX IF EXISTS idx_version_object_type_version_desc RENAME TO idx_version_unpartitioned_object_type_version_desc;
+
+CREATE TABLE version (
+                       id BIGINT NOT NULL DEFAULT nextval('version_id_seq'),
+                       object_type character varying(64) NOT NULL,
+                       object_id character varying(64) NOT NULL,
+                       version INTEGER NOT NULL,
+                       json JSONB NOT NULL,
+                       kind character varying(8) NOT NULL DEFAULT 'snapshot' CHECK (kind IN ('snapshot', 'patch')),
+                       action character varying(16) NOT NULL,
+                       actor character varying(128) NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+ALTER SEQUENCE version_id_seq OWNED BY version.id;
+
+CREATE OR REPLACE FUNCTION create_version_partition(day DATE) RETURNS BOOLEAN AS $$
+DECLARE
+    month_start DATE := date_trunc('month', day);
+    partition_name TEXT := 'version_' || to_char(month_start, 'YYYY_MM');
+    -- Bounds at midnight UTC whatever the session time zone, as the retention expects
+    lower_bound TIMESTAMPTZ := month_start::timestamp AT TIME ZONE 'UTC';
+BEGIN
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN FALSE;
+    END IF;
+    EXECUTE format('CREATE TABLE %I PARTITION OF version FOR VALUES FROM (%L) TO (%L)',
+                   partition_name, lower_bound, lower_bound + INTERVAL '1 month');
+    RETURN TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ensure_version_partitions(months_ahead INTEGER) RETURNS INTEGER AS $$
+    SELECT count(*)::integer
+    FROM generate_series(0, months_ahead) AS m
+    WHERE create_version_partition((date_trunc('month', NOW() AT TIME ZONE 'UTC') + m * INTERVAL '1 month')::date);
+$$ LANGUAGE sql;
+
+-- One partition per month holding versions, then the next ones
+SELECT create_version_partition(month::date)
+FROM (SELECT DISTINCT date_trunc('month', created_at AT TIME ZONE 'UTC') AS month FROM version_unpartitioned) AS months;
+SELECT ensure_version_partitions(3);
+
+INSERT INTO version (id, object_type, object_id, version, json, kind, action, actor, created_at)
+SELECT id, object_type, object_id, version, json, kind, action, actor, created_at
+FROM version_unpartitioned;
+
+DROP TABLE version_unpartitioned;
+
+CREATE INDEX idx_version_object_type_version_desc
+    ON version (object_id, object_type, version DESC)
+    INCLUDE (json);
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- Numbers versions from a counter per object that outlives the archived
+-- partitions, as declared in sqlc/models.sql. Fresh databases created from
+-- models.sql don't need it.
+-- The counters start from the versions still in the table: the numbers of
+-- objects whose versions were all archived before restart from 1.
+BEGIN;
+
+CREATE TABLE version_counters (
+                       object_type character varying(64) NOT NULL,
+                       object_id character varying(64) NOT NULL,
+                       last_version INTEGER NOT NULL,
+                       PRIMARY KEY (object_type, object_id)
+);
+
+-- No version is written meanwhile
+SELECT pg_advisory_xact_lock(hashtext('version'));
+
+INSERT INTO version_counters (object_type, object_id, last_version)
+SELECT object_type, object_id, MAX(version)
+FROM version
+GROUP BY object_type, object_id;
+
+COMMIT;
@@ -1,33 +1,108 @@
 package repository
 
 import (
+	"cognyx/psychic-robot/middleware"
 	"cognyx/psychic-robot/persistence/db"
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
+// Object type and actions of the versions of users
+const (
+	UserObjectType = "user"
+
+	VersionActionCreate = "create"
+	VersionActionUpdate = "update"
+	VersionActionDelete = "delete"
+	// VersionActionRestore writes back the snapshot of a previous version
+	VersionActionRestore = "restore"
+)
+
+// SystemActor is the actor of the versions written without authenticated principal
+const SystemActor = "system"
+
+// UserSnapshot is the state of a user recorded in its versions. The password
+// hash is left out.
+type UserSnapshot struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Roles     []string   `json:"roles"`
+	Deleted   bool       `json:"deleted"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Revision  int32      `json:"revision"`
+}
+
+// NewUserSnapshot returns the snapshot of a stored user
+func NewUserSnapshot(u db.User) UserSnapshot {
+	snapshot := UserSnapshot{
+		ID:        u.ID,
+		Name:      u.Name,
+		Email:     u.Email,
+		Roles:     u.Roles,
+		Deleted:   u.Deleted,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Revision:  u.Revision,
+	}
+	if u.DeletedAt.Valid {
+		snapshot.DeletedAt = &u.DeletedAt.Time
+	}
+	return snapshot
+}
+
+// beginner opens transactions: pgxpool.Pool does, and pgx.Tx opens savepoints
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// PostgresUserRepository writes a version of the user with each create, update
+// and delete, in the same transaction
 type PostgresUserRepository struct {
-	q *db.Queries
+	conn db.DBTX
+	q    *db.Queries
 }
 
-func NewUserRepository(q *db.Queries) *PostgresUserRepository {
-	return &PostgresUserRepository{q: q}
+// NewUserRepository creates a user repository on a pool or a transaction.
+// Unlike the other repositories it takes the connection itself, on which it
+// opens the transactions of the versioned writes.
+func NewUserRepository(conn db.DBTX) *PostgresUserRepository {
+	return &PostgresUserRepository{conn: conn, q: db.New(conn)}
+}
+
+// WithTx returns a repository running its queries inside the given transaction
+func (r *PostgresUserRepository) WithTx(tx pgx.Tx) *PostgresUserRepository {
+	return NewUserRepository(tx)
 }
 
 func (r *PostgresUserRepository) Create(ctx context.Context, user db.User) (db.User, error) {
-	u, err := r.q.CreateUser(ctx, db.CreateUserParams{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Roles: user.Roles,
+	return r.versioned(ctx, func(q *db.Queries) (db.User, string, error) {
+		u, err := q.CreateUser(ctx, db.CreateUserParams{
+			ID:    user.ID,
+			Name:  user.Name,
+			Email: user.Email,
+			Roles: user.Roles,
+		})
+		return u, VersionActionCreate, err
 	})
+}
+
+func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (db.User, error) {
+	u, err := r.q.GetUserByID(ctx, id)
 	if err != nil {
 		return db.User{}, err
 	}
 	return u, nil
 }
 
-func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (db.User, error) {
-	u, err := r.q.GetUserByID(ctx, id)
+// GetByIDForUpdate reads a user and locks its row until the transaction ends
+func (r *PostgresUserRepository) GetByIDForUpdate(ctx context.Context, id string) (db.User, error) {
+	u, err := r.q.GetUserByIDForUpdate(ctx, id)
 	if err != nil {
 		return db.User{}, err
 	}
@@ -50,19 +125,329 @@ func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int32)
 	return users, nil
 }
 
+// ListSinceCheckpoint returns the users strictly after the (updatedAt, id)
+// checkpoint, ordered by that same key so results can be paged through.
+func (r *PostgresUserRepository) ListSinceCheckpoint(ctx context.Context, updatedAt time.Time, id string, limit int32) ([]db.User, error) {
+	users, err := r.q.ListUsersSinceCheckpoint(ctx, db.ListUsersSinceCheckpointParams{
+		MinUpdatedAt: updatedAt,
+		MinID:        id,
+		RowLimit:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (r *PostgresUserRepository) Update(ctx context.Context, user db.User) (db.User, error) {
-	u, err := r.q.UpdateUser(ctx, db.UpdateUserParams{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Roles: user.Roles,
+	return r.versioned(ctx, func(q *db.Queries) (db.User, string, error) {
+		u, err := q.UpdateUser(ctx, db.UpdateUserParams{
+			ID:    user.ID,
+			Name:  user.Name,
+			Email: user.Email,
+			Roles: user.Roles,
+		})
+		return u, VersionActionUpdate, err
+	})
+}
+
+// Upsert inserts the user, or overwrites the stored row when the id exists.
+// A deleted user is written as a tombstone right away, recorded as a
+// VersionActionDelete version.
+func (r *PostgresUserRepository) Upsert(ctx context.Context, user db.User) (db.User, error) {
+	return r.versioned(ctx, func(q *db.Queries) (db.User, string, error) {
+		u, err := q.UpsertUser(ctx, db.UpsertUserParams{
+			ID:      user.ID,
+			Name:    user.Name,
+			Email:   user.Email,
+			Roles:   user.Roles,
+			Deleted: user.Deleted,
+		})
+		// The revision trigger restarts at 1 on insert
+		action := VersionActionUpdate
+		switch {
+		case u.Deleted:
+			action = VersionActionDelete
+		case u.Revision == 1:
+			action = VersionActionCreate
+		}
+		return u, action, err
+	})
+}
+
+// Delete soft-deletes the user, keeping a tombstone that replicates the
+// deletion to the other clients until it gets purged
+func (r *PostgresUserRepository) Delete(ctx context.Context, id string) (db.User, error) {
+	return r.versioned(ctx, func(q *db.Queries) (db.User, string, error) {
+		u, err := q.DeleteUser(ctx, id)
+		return u, VersionActionDelete, err
+	})
+}
+
+// Restore writes a snapshot back as the current state of the user, recorded as
+// a VersionActionRestore version which is returned. Restoring a deleted state
+// deletes the user again.
+func (r *PostgresUserRepository) Restore(ctx context.Context, snapshot UserSnapshot) (db.User, db.Version, error) {
+	return r.versionedWrite(ctx, func(q *db.Queries) (db.User, string, error) {
+		u, err := q.UpsertUser(ctx, db.UpsertUserParams{
+			ID:      snapshot.ID,
+			Name:    snapshot.Name,
+			Email:   snapshot.Email,
+			Roles:   snapshot.Roles,
+			Deleted: snapshot.Deleted,
+		})
+		return u, VersionActionRestore, err
+	})
+}
+
+// Purge permanently removes the tombstones deleted before the given time
+func (r *PostgresUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return r.q.PurgeDeletedUsers(ctx, deletedBefore)
+}
+
+// SetPassword stores the bcrypt hash used by the login endpoint. The password
+// isn't part of the snapshots: no version is written.
+func (r *PostgresUserRepository) SetPassword(ctx context.Context, id, passwordHash string) error {
+	return r.q.SetUserPassword(ctx, db.SetUserPasswordParams{ID: id, PasswordHash: passwordHash})
+}
+
+// versioned runs write and records the written user as a version with the
+// action it returns, in one transaction. The actor is the principal of ctx.
+func (r *PostgresUserRepository) versioned(ctx context.Context, write func(q *db.Queries) (db.User, string, error)) (db.User, error) {
+	user, _, err := r.versionedWrite(ctx, write)
+	return user, err
+}
+
+// versionedWrite is versioned, also returning the written version
+func (r *PostgresUserRepository) versionedWrite(ctx context.Context, write func(q *db.Queries) (db.User, string, error)) (db.User, db.Version, error) {
+	conn, ok := r.conn.(beginner)
+	if !ok {
+		return db.User{}, db.Version{}, fmt.Errorf("versioned write needs a pool or a transaction, got %T", r.conn)
+	}
+
+	var user db.User
+	var version db.Version
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		q := db.New(tx)
+		u, action, err := write(q)
+		if err != nil {
+			return err
+		}
+		user = u
+		version, err = createVersion(ctx, q, UserObjectType, u.ID, action, NewUserSnapshot(u))
+		return err
 	})
 	if err != nil {
-		return db.User{}, err
+		return db.User{}, db.Version{}, err
 	}
-	return u, nil
+	return user, version, nil
+}
+
+// actorOf returns who makes the changes of ctx: its principal, or SystemActor
+func actorOf(ctx context.Context) string {
+	if p := middleware.PrincipalFromContext(ctx); p != nil && p.ID != "" {
+		return p.ID
+	}
+	return SystemActor
+}
+
+type PostgresRefreshTokenRepository struct {
+	q *db.Queries
+}
+
+func NewRefreshTokenRepository(q *db.Queries) *PostgresRefreshTokenRepository {
+	return &PostgresRefreshTokenRepository{q: q}
+}
+
+// WithTx returns a repository running its queries inside the given transaction
+func (r *PostgresRefreshTokenRepository) WithTx(tx pgx.Tx) *PostgresRefreshTokenRepository {
+	return &PostgresRefreshTokenRepository{q: r.q.WithTx(tx)}
+}
+
+func (r *PostgresRefreshTokenRepository) Create(ctx context.Context, token db.RefreshToken) (db.RefreshToken, error) {
+	t, err := r.q.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		UserID:    token.UserID,
+		TokenHash: token.TokenHash,
+		FamilyID:  token.FamilyID,
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return db.RefreshToken{}, err
+	}
+	return t, nil
+}
+
+// GetByHashForUpdate reads a refresh token and locks its row until the
+// transaction ends, so that concurrent refreshes can't both rotate it
+func (r *PostgresRefreshTokenRepository) GetByHashForUpdate(ctx context.Context, tokenHash string) (db.RefreshToken, error) {
+	t, err := r.q.GetRefreshTokenByHashForUpdate(ctx, tokenHash)
+	if err != nil {
+		return db.RefreshToken{}, err
+	}
+	return t, nil
+}
+
+func (r *PostgresRefreshTokenRepository) Revoke(ctx context.Context, id int64) error {
+	return r.q.RevokeRefreshToken(ctx, id)
+}
+
+// RevokeFamily revokes every token rotated from the same login
+func (r *PostgresRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.q.RevokeRefreshTokenFamily(ctx, familyID)
+}
+
+// RevokeUser revokes every refresh token of the user, returning how many were
+// still valid
+func (r *PostgresRefreshTokenRepository) RevokeUser(ctx context.Context, userID string) (int64, error) {
+	return r.q.RevokeUserRefreshTokens(ctx, userID)
+}
+
+type PostgresRevokedTokenRepository struct {
+	q *db.Queries
+}
+
+func NewRevokedTokenRepository(q *db.Queries) *PostgresRevokedTokenRepository {
+	return &PostgresRevokedTokenRepository{q: q}
+}
+
+func (r *PostgresRevokedTokenRepository) Revoke(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	return r.q.RevokeToken(ctx, db.RevokeTokenParams{Jti: jti, UserID: userID, ExpiresAt: expiresAt})
+}
+
+// ActiveRevocations returns the expiry of every revoked token not expired yet, by jti
+func (r *PostgresRevokedTokenRepository) ActiveRevocations(ctx context.Context) (map[string]time.Time, error) {
+	tokens, err := r.q.ListActiveRevokedTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		result[token.Jti] = token.ExpiresAt
+	}
+	return result, nil
+}
+
+// RevokeSubject revokes the tokens of the user issued up to revokedAt, until
+// expiresAt when they have all expired
+func (r *PostgresRevokedTokenRepository) RevokeSubject(ctx context.Context, userID string, revokedAt, expiresAt time.Time) error {
+	return r.q.RevokeSubject(ctx, db.RevokeSubjectParams{UserID: userID, RevokedAt: revokedAt, ExpiresAt: expiresAt})
+}
+
+// ActiveSubjectRevocations returns the time up to which the tokens of every
+// revoked user are rejected, by user
+func (r *PostgresRevokedTokenRepository) ActiveSubjectRevocations(ctx context.Context) (map[string]time.Time, error) {
+	subjects, err := r.q.ListActiveRevokedSubjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]time.Time, len(subjects))
+	for _, subject := range subjects {
+		result[subject.UserID] = subject.RevokedAt
+	}
+	return result, nil
+}
+
+// PurgeExpired removes the revocations of expired tokens, which are rejected anyway
+func (r *PostgresRevokedTokenRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	tokens, err := r.q.PurgeExpiredRevokedTokens(ctx)
+	if err != nil {
+		return 0, err
+	}
+	subjects, err := r.q.PurgeExpiredRevokedSubjects(ctx)
+	if err != nil {
+		return tokens, err
+	}
+	return tokens + subjects, nil
+}
+
+type PostgresAPIKeyRepository struct {
+	q *db.Queries
+}
+
+func NewAPIKeyRepository(q *db.Queries) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{q: q}
+}
+
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key db.ApiKey) (db.ApiKey, error) {
+	k, err := r.q.CreateAPIKey(ctx, db.CreateAPIKeyParams{
+		ID:        key.ID,
+		Name:      key.Name,
+		KeyPrefix: key.KeyPrefix,
+		KeyHash:   key.KeyHash,
+		Scopes:    key.Scopes,
+		CreatedBy: key.CreatedBy,
+		ExpiresAt: key.ExpiresAt,
+	})
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return k, nil
+}
+
+func (r *PostgresAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (db.ApiKey, error) {
+	k, err := r.q.GetAPIKeyByHash(ctx, keyHash)
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return k, nil
+}
+
+func (r *PostgresAPIKeyRepository) List(ctx context.Context) ([]db.ApiKey, error) {
+	keys, err := r.q.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke revokes the key, reporting false when there is no such active key
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id string) (bool, error) {
+	revoked, err := r.q.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return revoked > 0, nil
+}
+
+// Touch records the key was just used, at most once a minute
+func (r *PostgresAPIKeyRepository) Touch(ctx context.Context, id string) error {
+	return r.q.TouchAPIKey(ctx, id)
+}
+
+type PostgresAuthEventRepository struct {
+	q *db.Queries
+}
+
+func NewAuthEventRepository(q *db.Queries) *PostgresAuthEventRepository {
+	return &PostgresAuthEventRepository{q: q}
+}
+
+func (r *PostgresAuthEventRepository) Create(ctx context.Context, event db.AuthEvent) error {
+	return r.q.CreateAuthEvent(ctx, db.CreateAuthEventParams{
+		CreatedAt:   event.CreatedAt,
+		Transport:   event.Transport,
+		Method:      event.Method,
+		Success:     event.Success,
+		PrincipalID: event.PrincipalID,
+		Email:       event.Email,
+		TenantID:    event.TenantID,
+		TokenID:     event.TokenID,
+		RemoteAddr:  event.RemoteAddr,
+		UserAgent:   event.UserAgent,
+		Reason:      event.Reason,
+	})
+}
+
+// List returns the events matching the filter, newest first
+func (r *PostgresAuthEventRepository) List(ctx context.Context, filter db.ListAuthEventsParams) ([]db.AuthEvent, error) {
+	events, err := r.q.ListAuthEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
-func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
-	return r.q.DeleteUser(ctx, id)
+// Purge removes the events recorded before createdBefore
+func (r *PostgresAuthEventRepository) Purge(ctx context.Context, createdBefore time.Time) (int64, error) {
+	return r.q.PurgeAuthEvents(ctx, createdBefore)
 }
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestUserSnapshotLeavesOutPassword(t *testing.T) {
+	deletedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := db.User{
+		ID:           "alice",
+		Email:        "alice@example.com",
+		Roles:        []string{"user"},
+		Deleted:      true,
+		DeletedAt:    pgtype.Timestamptz{Time: deletedAt, Valid: true},
+		Revision:     3,
+		PasswordHash: "$2a$10$secret",
+	}
+
+	data, err := json.Marshal(NewUserSnapshot(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "password") {
+		t.Errorf("Expected no password in the snapshot, got %s", data)
+	}
+
+	var snapshot UserSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ID != "alice" || !snapshot.Deleted || snapshot.DeletedAt == nil || !snapshot.DeletedAt.Equal(deletedAt) || snapshot.Revision != 3 {
+		t.Errorf("Expected the state of alice, got %+v", snapshot)
+	}
+}
+
+func TestActorOf(t *testing.T) {
+	if actor := actorOf(context.Background()); actor != SystemActor {
+		t.Errorf("Expected %s without principal, got %s", SystemActor, actor)
+	}
+	ctx := middleware.WithPrincipal(context.Background(), &middleware.Principal{ID: "admin-1"})
+	if actor := actorOf(ctx); actor != "admin-1" {
+		t.Errorf("Expected the principal as actor, got %s", actor)
+	}
+}
@@ -3,14 +3,71 @@ package repository
 import (
 	"cognyx/psychic-robot/persistence/db"
 	"context"
+	"time"
 )
 
 // Interface pour User
 type UserRepository interface {
 	Create(ctx context.Context, user db.User) (db.User, error)
 	GetByID(ctx context.Context, id string) (db.User, error)
+	GetByIDForUpdate(ctx context.Context, id string) (db.User, error)
 	GetByEmail(ctx context.Context, email string) (db.User, error)
 	List(ctx context.Context, limit, offset int32) ([]db.User, error)
+	ListSinceCheckpoint(ctx context.Context, updatedAt time.Time, id string, limit int32) ([]db.User, error)
 	Update(ctx context.Context, user db.User) (db.User, error)
-	Delete(ctx context.Context, id string) error
+	Upsert(ctx context.Context, user db.User) (db.User, error)
+	Delete(ctx context.Context, id string) (db.User, error)
+	Restore(ctx context.Context, snapshot UserSnapshot) (db.User, db.Version, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	SetPassword(ctx context.Context, id, passwordHash string) error
+}
+
+// Interface pour RefreshToken
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token db.RefreshToken) (db.RefreshToken, error)
+	GetByHashForUpdate(ctx context.Context, tokenHash string) (db.RefreshToken, error)
+	Revoke(ctx context.Context, id int64) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID string) (int64, error)
+}
+
+// Interface pour RevokedToken
+type RevokedTokenRepository interface {
+	Revoke(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	ActiveRevocations(ctx context.Context) (map[string]time.Time, error)
+	RevokeSubject(ctx context.Context, userID string, revokedAt, expiresAt time.Time) error
+	ActiveSubjectRevocations(ctx context.Context) (map[string]time.Time, error)
+	PurgeExpired(ctx context.Context) (int64, error)
+}
+
+// Interface pour ApiKey
+type APIKeyRepository interface {
+	Create(ctx context.Context, key db.ApiKey) (db.ApiKey, error)
+	GetByHash(ctx context.Context, keyHash string) (db.ApiKey, error)
+	List(ctx context.Context) ([]db.ApiKey, error)
+	Revoke(ctx context.Context, id string) (bool, error)
+	Touch(ctx context.Context, id string) error
+}
+
+// Interface pour AuthEvent
+type AuthEventRepository interface {
+	Create(ctx context.Context, event db.AuthEvent) error
+	List(ctx context.Context, filter db.ListAuthEventsParams) ([]db.AuthEvent, error)
+	Purge(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+// Interface pour Version
+type VersionRepository interface {
+	Get(ctx context.Context, objectType, objectID string, version int32) (db.Version, error)
+	List(ctx context.Context, filter db.ListVersionsParams) ([]db.ListVersionsRow, error)
+}
+
+// Interface pour les partitions de Version
+type VersionPartitionRepository interface {
+	EnsurePartitions(ctx context.Context, monthsAhead int32) (int32, error)
+	ListPartitions(ctx context.Context) ([]string, error)
+	LockVersions(ctx context.Context, fn func(VersionPartitionRepository) error) error
+	RebasePatches(ctx context.Context, before time.Time) (int, error)
+	ExportPartition(ctx context.Context, name string, emit func(db.Version) error) error
+	DropPartition(ctx context.Context, name string) error
 }
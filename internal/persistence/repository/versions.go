@@ -0,0 +1,255 @@
+package repository
+
+import (
+	jsoncompare "cognyx/psychic-robot/json"
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kinds of the stored versions: the full state of the object, or the JSON
+// Patch from the previous version
+const (
+	VersionKindSnapshot = "snapshot"
+	VersionKindPatch    = "patch"
+)
+
+// DefaultSnapshotInterval stores every version as a full snapshot
+const DefaultSnapshotInterval = 1
+
+var snapshotInterval = DefaultSnapshotInterval
+
+// ConfigureSnapshotInterval sets how versions are stored: a full snapshot every
+// interval versions, the ones in between as the RFC 6902 JSON Patch from their
+// previous version. Reads rebuild the patched versions whatever the interval,
+// so it can change on a populated table. It must be called at startup.
+func ConfigureSnapshotInterval(interval int) {
+	snapshotInterval = max(interval, DefaultSnapshotInterval)
+}
+
+type PostgresVersionRepository struct {
+	q *db.Queries
+}
+
+func NewVersionRepository(q *db.Queries) *PostgresVersionRepository {
+	return &PostgresVersionRepository{q: q}
+}
+
+// Get returns a version with the full state of the object in Json, rebuilt
+// from its closest snapshot when it is stored as a patch
+func (r *PostgresVersionRepository) Get(ctx context.Context, objectType, objectID string, version int32) (db.Version, error) {
+	chain, err := r.q.ListVersionChain(ctx, db.ListVersionChainParams{ObjectID: objectID, ObjectType: objectType, Upto: version})
+	if err != nil {
+		return db.Version{}, err
+	}
+	if len(chain) == 0 || chain[len(chain)-1].Version != version {
+		return db.Version{}, pgx.ErrNoRows
+	}
+	v := chain[len(chain)-1]
+	if v.Json, err = rebuildVersion(chain); err != nil {
+		return db.Version{}, err
+	}
+	return v, nil
+}
+
+// List returns the versions of an object matching the filter, newest first,
+// without their snapshot
+func (r *PostgresVersionRepository) List(ctx context.Context, filter db.ListVersionsParams) ([]db.ListVersionsRow, error) {
+	versions, err := r.q.ListVersions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// createVersion appends the snapshot to the versions of the object, stored as
+// configured by ConfigureSnapshotInterval. The returned version holds the
+// snapshot in Json. It must run in a transaction: it holds the lock of the
+// version writes until the end of it, so that the partition its patch follows
+// can't be archived meanwhile.
+func createVersion(ctx context.Context, q *db.Queries, objectType, objectID, action string, snapshot any) (db.Version, error) {
+	state, err := json.Marshal(snapshot)
+	if err != nil {
+		return db.Version{}, fmt.Errorf("encoding the %s %s snapshot: %w", objectType, objectID, err)
+	}
+	if err := q.LockVersionWrites(ctx); err != nil {
+		return db.Version{}, err
+	}
+	params := db.CreateVersionParams{
+		ObjectType: objectType,
+		ObjectID:   objectID,
+		Json:       state,
+		Kind:       VersionKindSnapshot,
+		Action:     action,
+		Actor:      actorOf(ctx),
+	}
+
+	var chain []db.Version
+	if snapshotInterval > 1 {
+		chain, err = q.ListVersionChain(ctx, db.ListVersionChainParams{ObjectID: objectID, ObjectType: objectType, Upto: math.MaxInt32})
+		if err != nil {
+			return db.Version{}, err
+		}
+		if params.Json, params.Kind, err = encodeVersion(chain, state, snapshotInterval); err != nil {
+			return db.Version{}, fmt.Errorf("encoding the %s %s patch: %w", objectType, objectID, err)
+		}
+	}
+
+	v, err := q.CreateVersion(ctx, params)
+	if err != nil {
+		return db.Version{}, err
+	}
+	// A patch only applies to the version it was computed from
+	if len(chain) > 0 && v.Version != chain[len(chain)-1].Version+1 {
+		return db.Version{}, fmt.Errorf("%s %s versioned concurrently: got version %d after %d", objectType, objectID, v.Version, chain[len(chain)-1].Version)
+	}
+	v.Json = state
+	return v, nil
+}
+
+// encodeVersion returns how to store the state following the chain of the
+// latest versions: as a snapshot when the chain holds interval versions already
+// or when the patch would not be smaller, as a patch otherwise
+func encodeVersion(chain []db.Version, state []byte, interval int) ([]byte, string, error) {
+	if len(chain) == 0 || len(chain) >= interval {
+		return state, VersionKindSnapshot, nil
+	}
+	previous, err := rebuildVersion(chain)
+	if err != nil {
+		return nil, "", err
+	}
+	patch, err := jsoncompare.CompareJSON(previous, state)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(patch) >= len(state) {
+		return state, VersionKindSnapshot, nil
+	}
+	return patch, VersionKindPatch, nil
+}
+
+// rebuildVersion returns the state of the last version of the chain, applying
+// its patches in order to the snapshot it starts with
+func rebuildVersion(chain []db.Version) ([]byte, error) {
+	if len(chain) == 0 || chain[0].Kind != VersionKindSnapshot {
+		return nil, fmt.Errorf("no snapshot to rebuild the version from")
+	}
+	patches := make([][]byte, 0, len(chain)-1)
+	for _, v := range chain[1:] {
+		if v.Kind != VersionKindPatch {
+			return nil, fmt.Errorf("version %d: expected a patch, got a %s", v.Version, v.Kind)
+		}
+		patches = append(patches, v.Json)
+	}
+	state, err := jsoncompare.ApplyPatch(chain[0].Json, patches...)
+	if err != nil {
+		return nil, fmt.Errorf("rebuilding version %d: %w", chain[len(chain)-1].Version, err)
+	}
+	return state, nil
+}
+
+// PostgresVersionPartitionRepository manages the monthly partitions of the
+// version table
+type PostgresVersionPartitionRepository struct {
+	// conn is the pool, or the transaction of LockVersions
+	conn interface {
+		db.DBTX
+		beginner
+	}
+	q *db.Queries
+}
+
+func NewVersionPartitionRepository(pool *pgxpool.Pool) *PostgresVersionPartitionRepository {
+	return &PostgresVersionPartitionRepository{conn: pool, q: db.New(pool)}
+}
+
+// LockVersions runs fn in a transaction blocking the version writes, the ones
+// in progress being committed first. The repository fn is given works in that
+// transaction, so the versions it rebases can't be patched before it drops
+// their partition.
+func (r *PostgresVersionPartitionRepository) LockVersions(ctx context.Context, fn func(VersionPartitionRepository) error) error {
+	return pgx.BeginFunc(ctx, r.conn, func(tx pgx.Tx) error {
+		q := db.New(tx)
+		if err := q.LockVersions(ctx); err != nil {
+			return err
+		}
+		return fn(&PostgresVersionPartitionRepository{conn: tx, q: q})
+	})
+}
+
+// EnsurePartitions creates the partitions of the current month and of the
+// monthsAhead next ones, returning how many were missing
+func (r *PostgresVersionPartitionRepository) EnsurePartitions(ctx context.Context, monthsAhead int32) (int32, error) {
+	return r.q.EnsureVersionPartitions(ctx, monthsAhead)
+}
+
+// ListPartitions returns the names of the partitions, oldest first
+func (r *PostgresVersionPartitionRepository) ListPartitions(ctx context.Context) ([]string, error) {
+	return r.q.ListVersionPartitions(ctx)
+}
+
+// RebasePatches stores as snapshots the versions from before on that are
+// patches of older versions, so that they can still be rebuilt once the older
+// versions are gone. It returns how many were rebased.
+func (r *PostgresVersionPartitionRepository) RebasePatches(ctx context.Context, before time.Time) (int, error) {
+	rebased := 0
+	err := pgx.BeginFunc(ctx, r.conn, func(tx pgx.Tx) error {
+		q := db.New(tx)
+		patches, err := q.ListPatchesAfter(ctx, before)
+		if err != nil {
+			return err
+		}
+		versions := NewVersionRepository(q)
+		for _, patch := range patches {
+			v, err := versions.Get(ctx, patch.ObjectType, patch.ObjectID, patch.Version)
+			if err != nil {
+				return fmt.Errorf("rebuilding version %d of %s %s: %w", patch.Version, patch.ObjectType, patch.ObjectID, err)
+			}
+			if err := q.RebaseVersion(ctx, db.RebaseVersionParams{ID: patch.ID, CreatedAt: patch.CreatedAt, Json: v.Json}); err != nil {
+				return err
+			}
+		}
+		rebased = len(patches)
+		return nil
+	})
+	return rebased, err
+}
+
+// ExportPartition streams the versions of a partition to emit, in id order.
+// Partition names aren't known statically, hence the query built here.
+func (r *PostgresVersionPartitionRepository) ExportPartition(ctx context.Context, name string, emit func(db.Version) error) error {
+	rows, err := r.conn.Query(ctx, `SELECT id, object_type, object_id, version, json, kind, action, actor, created_at FROM `+
+		pgx.Identifier{name}.Sanitize()+` ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v db.Version
+		if err := rows.Scan(&v.ID, &v.ObjectType, &v.ObjectID, &v.Version, &v.Json, &v.Kind, &v.Action, &v.Actor, &v.CreatedAt); err != nil {
+			return err
+		}
+		if err := emit(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DropPartition detaches a partition from the version table and drops it
+func (r *PostgresVersionPartitionRepository) DropPartition(ctx context.Context, name string) error {
+	partition := pgx.Identifier{name}.Sanitize()
+	return pgx.BeginFunc(ctx, r.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `ALTER TABLE version DETACH PARTITION `+partition); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DROP TABLE `+partition)
+		return err
+	})
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/dbtest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// storeVersions encodes the states like createVersion does, returning the
+// stored versions numbered from 1
+func storeVersions(t testing.TB, states [][]byte, interval int) []db.Version {
+	var stored []db.Version
+	for i, state := range states {
+		data, kind, err := encodeVersion(chainOf(stored, int32(len(stored))), state, interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, db.Version{Version: int32(i + 1), Json: data, Kind: kind})
+	}
+	return stored
+}
+
+// chainOf returns what ListVersionChain would for version upto
+func chainOf(stored []db.Version, upto int32) []db.Version {
+	if upto == 0 {
+		return nil
+	}
+	start := upto - 1
+	for start > 0 && stored[start].Kind != VersionKindSnapshot {
+		start--
+	}
+	return stored[start:upto]
+}
+
+// datamodelStates returns successive states of a datamodel of the given
+// number of attributes, each changing a couple of them
+func datamodelStates(count, attributes int) [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	model := map[string]any{"name": "Model #1"}
+	fields := make([]map[string]any, attributes)
+	for i := range fields {
+		fields[i] = map[string]any{"name": fmt.Sprintf("attribute_%d", i), "type": "string", "required": false}
+	}
+	states := make([][]byte, count)
+	for v := range states {
+		for range 2 {
+			field := fields[rnd.Intn(attributes)]
+			field["required"] = !field["required"].(bool)
+			field["type"] = []string{"string", "number", "boolean", "date"}[rnd.Intn(4)]
+		}
+		model["attributes"] = fields
+		model["revision"] = v + 1
+		states[v], _ = json.Marshal(model)
+	}
+	return states
+}
+
+func TestDeltaVersionsRebuild(t *testing.T) {
+	states := datamodelStates(25, 20)
+	stored := storeVersions(t, states, 10)
+
+	for _, n := range []int{1, 11, 21} {
+		if stored[n-1].Kind != VersionKindSnapshot {
+			t.Errorf("Expected version %d to be a snapshot, got a %s", n, stored[n-1].Kind)
+		}
+	}
+	if stored[1].Kind != VersionKindPatch || len(stored[1].Json) >= len(states[1]) {
+		t.Errorf("Expected version 2 to be a smaller patch, got a %s of %d bytes", stored[1].Kind, len(stored[1].Json))
+	}
+
+	for i, state := range states {
+		rebuilt, err := rebuildVersion(chainOf(stored, int32(i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want any
+		json.Unmarshal(rebuilt, &got)
+		json.Unmarshal(state, &want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected version %d to be rebuilt, got %s", i+1, rebuilt)
+		}
+	}
+}
+
+func TestDeltaVersionsKeepSmallSnapshots(t *testing.T) {
+	// The patch of a tiny user is bigger than its state
+	states := [][]byte{[]byte(`{"id":"alice","name":"a"}`), []byte(`{"id":"alice","name":"b"}`)}
+	if stored := storeVersions(t, states, 10); stored[1].Kind != VersionKindSnapshot {
+		t.Errorf("Expected a snapshot when the patch isn't smaller, got %s", stored[1].Json)
+	}
+}
+
+func TestRebuildVersionNeedsSnapshot(t *testing.T) {
+	if _, err := rebuildVersion([]db.Version{{Version: 2, Kind: VersionKindPatch, Json: []byte(`[]`)}}); err == nil {
+		t.Errorf("Expected a chain without snapshot to be rejected")
+	}
+}
+
+// BenchmarkVersionStorage compares full snapshots (interval 1) with patches
+// between periodic snapshots: bytes/version is the storage size. ns/op only
+// covers applying the patches of a random version in memory, see
+// BenchmarkVersionRead for reading them.
+func BenchmarkVersionStorage(b *testing.B) {
+	states := datamodelStates(500, 200)
+	for _, interval := range []int{1, 10, 50, 100} {
+		b.Run(fmt.Sprintf("interval=%d", interval), func(b *testing.B) {
+			stored := storeVersions(b, states, interval)
+			size := 0
+			for _, v := range stored {
+				size += len(v.Json)
+			}
+			rnd := rand.New(rand.NewSource(1))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := rebuildVersion(chainOf(stored, int32(rnd.Intn(len(stored))+1))); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(size)/float64(len(stored)), "bytes/version")
+		})
+	}
+}
+
+// BenchmarkVersionRead compares the reads of full snapshots (interval 1) and of
+// patches between periodic snapshots against Postgres: ns/op is the read of a
+// random version, fetching its chain with ListVersionChain and rebuilding it.
+func BenchmarkVersionRead(b *testing.B) {
+	pool := dbtest.New(b)
+	ctx := context.Background()
+	q := db.New(pool)
+	if _, err := q.EnsureVersionPartitions(ctx, 0); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ConfigureSnapshotInterval(DefaultSnapshotInterval) })
+
+	states := datamodelStates(500, 200)
+	for _, interval := range []int{1, 10, 50, 100} {
+		b.Run(fmt.Sprintf("interval=%d", interval), func(b *testing.B) {
+			ConfigureSnapshotInterval(interval)
+			id := fmt.Sprintf("model-%d", interval)
+			err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+				for _, state := range states {
+					if _, err := createVersion(ctx, db.New(tx), "datamodel", id, VersionActionUpdate, json.RawMessage(state)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			versions := NewVersionRepository(q)
+			rnd := rand.New(rand.NewSource(1))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := versions.Get(ctx, "datamodel", id, int32(rnd.Intn(len(states))+1)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
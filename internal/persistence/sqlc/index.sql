@@ -2,8 +2,31 @@ CREATE INDEX idx_version_object_type_version_desc
     ON version (object_id, object_type, version DESC)
     INCLUDE (json);
 
--- careful with this, creates an index to verify unicity, so can slow everything down.
--- NOT EXECUTED FOR NOW
-ALTER TABLE version
-    ADD CONSTRAINT unique_object_version
-        UNIQUE (object_type, object_id, version);
\ No newline at end of file
+-- No UNIQUE (object_type, object_id, version): the unique constraints of a
+-- partitioned table must include created_at. Versions are numbered under the
+-- lock of the object's version_counters row instead (see CreateVersion).
+
+-- keyset pagination for the replication pull (checkpoint = updated_at, id)
+CREATE INDEX idx_users_updated_at_id
+    ON users (updated_at, id);
+
+CREATE INDEX idx_refresh_tokens_family_id
+    ON refresh_tokens (family_id);
+
+CREATE INDEX idx_revoked_tokens_expires_at
+    ON revoked_tokens (expires_at);
+
+CREATE INDEX idx_revoked_subjects_expires_at
+    ON revoked_subjects (expires_at);
+-- auth audit log: retention purge and the investigation filters
+CREATE INDEX idx_auth_events_created_at
+    ON auth_events (created_at);
+
+CREATE INDEX idx_auth_events_remote_addr_id
+    ON auth_events (remote_addr, id);
+
+CREATE INDEX idx_auth_events_email_id
+    ON auth_events (email, id);
+
+CREATE INDEX idx_auth_events_principal_id_id
+    ON auth_events (principal_id, id);
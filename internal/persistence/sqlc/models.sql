@@ -4,5 +4,152 @@ CREATE TABLE users (
                            email TEXT NOT NULL UNIQUE,
                        roles TEXT[] NOT NULL DEFAULT '{}',
                        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-                       updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);
\ No newline at end of file
+                       -- The time of the write statement rather than of its transaction,
+                       -- which can commit long after: the replication pull checkpoints on it
+                       updated_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+                       deleted BOOLEAN NOT NULL DEFAULT FALSE,
+                       deleted_at TIMESTAMPTZ,
+                       revision INTEGER NOT NULL DEFAULT 1,
+                       rev_hash character varying(32) NOT NULL DEFAULT '',
+                       -- bcrypt hash, empty for users who can't log in
+                       password_hash TEXT NOT NULL DEFAULT ''
+);
+
+-- Every replicated write bumps the RxDB revision (<revision>-<rev_hash>) of the row,
+-- the hash being computed from the replicated content. Updates leaving the
+-- replicated columns untouched (e.g. a password change) keep the revision.
+CREATE FUNCTION users_bump_revision() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND row(NEW.name, NEW.email, NEW.roles, NEW.deleted, NEW.updated_at)
+        IS NOT DISTINCT FROM row(OLD.name, OLD.email, OLD.roles, OLD.deleted, OLD.updated_at) THEN
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' THEN
+        NEW.revision := OLD.revision + 1;
+    ELSE
+        NEW.revision := 1;
+    END IF;
+    NEW.rev_hash := md5(row(NEW.id, NEW.name, NEW.email, NEW.roles, NEW.deleted)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_revision
+    BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_bump_revision();
+
+-- Every create, update and delete of an object appends a version: the state
+-- of the object after the change, and who made it.
+-- Versions of an object are numbered from 1, by its version_counters row. The json of a 'snapshot' is the
+-- full state, the one of a 'patch' the RFC 6902 JSON Patch from the previous
+-- version (see VERSION_SNAPSHOT_INTERVAL).
+-- The table is partitioned by month of created_at: version_YYYY_MM holds the
+-- versions of that month. Partitions are created ahead by
+-- ensure_version_partitions, and the old ones archived by the retention job.
+CREATE TABLE version (
+                       id BIGSERIAL,
+                       object_type character varying(64) NOT NULL,
+                       object_id character varying(64) NOT NULL,
+                       version INTEGER NOT NULL,
+                       json JSONB NOT NULL,
+                       kind character varying(8) NOT NULL DEFAULT 'snapshot' CHECK (kind IN ('snapshot', 'patch')),
+                       action character varying(16) NOT NULL,
+                       actor character varying(128) NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- The last version number of every versioned object. It outlives the archived
+-- versions, so that their numbers are never given again.
+CREATE TABLE version_counters (
+                       object_type character varying(64) NOT NULL,
+                       object_id character varying(64) NOT NULL,
+                       last_version INTEGER NOT NULL,
+                       PRIMARY KEY (object_type, object_id)
+);
+
+-- Creates the partition of the month of the given day, if missing. Returns
+-- whether it was created.
+CREATE FUNCTION create_version_partition(day DATE) RETURNS BOOLEAN AS $$
+DECLARE
+    month_start DATE := date_trunc('month', day);
+    partition_name TEXT := 'version_' || to_char(month_start, 'YYYY_MM');
+    -- Bounds at midnight UTC whatever the session time zone, as the retention expects
+    lower_bound TIMESTAMPTZ := month_start::timestamp AT TIME ZONE 'UTC';
+BEGIN
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN FALSE;
+    END IF;
+    EXECUTE format('CREATE TABLE %I PARTITION OF version FOR VALUES FROM (%L) TO (%L)',
+                   partition_name, lower_bound, lower_bound + INTERVAL '1 month');
+    RETURN TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Creates the partitions of the current month and of the months_ahead next
+-- ones, returning how many were missing
+CREATE FUNCTION ensure_version_partitions(months_ahead INTEGER) RETURNS INTEGER AS $$
+    SELECT count(*)::integer
+    FROM generate_series(0, months_ahead) AS m
+    WHERE create_version_partition((date_trunc('month', NOW() AT TIME ZONE 'UTC') + m * INTERVAL '1 month')::date);
+$$ LANGUAGE sql;
+
+-- Refresh tokens are only stored as SHA-256 hashes. Each refresh rotates the
+-- token within its family; presenting a revoked token revokes the whole family.
+CREATE TABLE refresh_tokens (
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id character varying(64) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       token_hash character(64) NOT NULL UNIQUE,
+                       family_id character varying(64) NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       revoked_at TIMESTAMPTZ
+);
+
+-- Access tokens revoked before their expiry, by jti. Rows are purged once the
+-- token has expired anyway.
+CREATE TABLE revoked_tokens (
+                       jti character varying(64) PRIMARY KEY,
+                       user_id character varying(64) NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL,
+                       revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Users whose tokens were all revoked: their access tokens issued up to
+-- revoked_at are rejected. Rows are purged once those tokens have expired.
+CREATE TABLE revoked_subjects (
+                       user_id character varying(64) PRIMARY KEY,
+                       revoked_at TIMESTAMPTZ NOT NULL,
+                       expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- API keys of batch jobs and backend services, only stored as SHA-256 hashes.
+-- Scopes are the permissions granted to the key.
+CREATE TABLE api_keys (
+                       id character varying(64) PRIMARY KEY,
+                       name character varying NOT NULL,
+                       key_prefix character varying(16) NOT NULL,
+                       key_hash character(64) NOT NULL UNIQUE,
+                       scopes TEXT[] NOT NULL DEFAULT '{}',
+                       created_by character varying(64) NOT NULL,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       expires_at TIMESTAMPTZ,
+                       last_used_at TIMESTAMPTZ,
+                       revoked_at TIMESTAMPTZ
+);
+-- Authentication decisions of every transport, successful or not. principal_id
+-- is empty for failures; email is then the one attempted, if any.
+CREATE TABLE auth_events (
+                       id BIGSERIAL PRIMARY KEY,
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       transport character varying(16) NOT NULL,
+                       method character varying(16) NOT NULL,
+                       success BOOLEAN NOT NULL,
+                       principal_id character varying(128) NOT NULL DEFAULT '',
+                       email character varying NOT NULL DEFAULT '',
+                       tenant_id character varying(64) NOT NULL DEFAULT '',
+                       token_id character varying(64) NOT NULL DEFAULT '',
+                       remote_addr character varying(64) NOT NULL DEFAULT '',
+                       user_agent TEXT NOT NULL DEFAULT '',
+                       reason TEXT NOT NULL DEFAULT ''
+);
@@ -7,12 +7,18 @@ RETURNING *;
 SELECT * FROM users
 WHERE id = $1;
 
+-- name: GetUserByIDForUpdate :one
+SELECT * FROM users
+WHERE id = $1
+FOR UPDATE;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users
 WHERE email = $1;
 
 -- name: ListUsers :many
 SELECT * FROM users
+WHERE NOT deleted
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2;
 
@@ -21,10 +27,235 @@ UPDATE users
 SET name = $2,
     email = $3,
     roles = $4,
-    updated_at = NOW()
+    updated_at = clock_timestamp()
 WHERE id = $1
 RETURNING *;
 
--- name: DeleteUser :exec
+-- name: UpsertUser :one
+-- Writes the user, deleted or not, in one statement: a tombstone is a single
+-- revision of the row
+INSERT INTO users (id, name, email, roles, deleted, deleted_at)
+VALUES ($1, $2, $3, $4, sqlc.arg(deleted)::boolean,
+        CASE WHEN sqlc.arg(deleted)::boolean THEN clock_timestamp() END)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name,
+    email = EXCLUDED.email,
+    roles = EXCLUDED.roles,
+    updated_at = clock_timestamp(),
+    deleted = EXCLUDED.deleted,
+    deleted_at = CASE WHEN EXCLUDED.deleted THEN COALESCE(users.deleted_at, clock_timestamp()) END
+RETURNING *;
+
+-- name: DeleteUser :one
+UPDATE users
+SET deleted = TRUE,
+    deleted_at = clock_timestamp(),
+    updated_at = clock_timestamp()
+WHERE id = $1
+RETURNING *;
+
+-- name: PurgeDeletedUsers :execrows
 DELETE FROM users
+WHERE deleted
+  AND deleted_at < sqlc.arg(deleted_before)::timestamptz;
+
+-- name: ListUsersSinceCheckpoint :many
+SELECT * FROM users
+WHERE (updated_at, id) > (sqlc.arg(min_updated_at)::timestamptz, sqlc.arg(min_id)::varchar)
+ORDER BY updated_at ASC, id ASC
+LIMIT sqlc.arg(row_limit);
+
+
+-- name: CreateVersion :one
+-- The version is numbered from the counter of the object, which stays locked
+-- until the transaction ends, so concurrent changes can't get the same number
+WITH counter AS (
+    INSERT INTO version_counters (object_type, object_id, last_version)
+    VALUES (sqlc.arg(object_type)::varchar, sqlc.arg(object_id)::varchar, 1)
+    ON CONFLICT (object_type, object_id) DO UPDATE
+    SET last_version = version_counters.last_version + 1
+    RETURNING last_version
+)
+INSERT INTO version (object_type, object_id, version, json, kind, action, actor)
+SELECT sqlc.arg(object_type)::varchar, sqlc.arg(object_id)::varchar, counter.last_version,
+       sqlc.arg(json)::jsonb, sqlc.arg(kind)::varchar, sqlc.arg(action)::varchar, sqlc.arg(actor)::varchar
+FROM counter
+RETURNING *;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash = $2
 WHERE id = $1;
+
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetRefreshTokenByHashForUpdate :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (jti, user_id, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: ListActiveRevokedTokens :many
+SELECT * FROM revoked_tokens
+WHERE expires_at > NOW();
+
+-- name: PurgeExpiredRevokedTokens :execrows
+DELETE FROM revoked_tokens
+WHERE expires_at <= NOW();
+
+-- name: RevokeSubject :exec
+INSERT INTO revoked_subjects (user_id, revoked_at, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET revoked_at = GREATEST(revoked_subjects.revoked_at, EXCLUDED.revoked_at),
+    expires_at = GREATEST(revoked_subjects.expires_at, EXCLUDED.expires_at);
+
+-- name: ListActiveRevokedSubjects :many
+SELECT * FROM revoked_subjects
+WHERE expires_at > NOW();
+
+-- name: PurgeExpiredRevokedSubjects :execrows
+DELETE FROM revoked_subjects
+WHERE expires_at <= NOW();
+
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, name, key_prefix, key_hash, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetAPIKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = $1;
+
+-- name: ListAPIKeys :many
+SELECT * FROM api_keys
+ORDER BY created_at DESC;
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL;
+
+-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (created_at, transport, method, success, principal_id, email, tenant_id, token_id, remote_addr, user_agent, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+
+-- name: ListAuthEvents :many
+SELECT * FROM auth_events
+WHERE (sqlc.narg(transport)::varchar IS NULL OR transport = sqlc.narg(transport))
+  AND (sqlc.narg(method)::varchar IS NULL OR method = sqlc.narg(method))
+  AND (sqlc.narg(success)::boolean IS NULL OR success = sqlc.narg(success))
+  AND (sqlc.narg(principal_id)::varchar IS NULL OR principal_id = sqlc.narg(principal_id))
+  AND (sqlc.narg(email)::varchar IS NULL OR email = sqlc.narg(email))
+  AND (sqlc.narg(remote_addr)::varchar IS NULL OR remote_addr = sqlc.narg(remote_addr))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR created_at < sqlc.narg(until))
+  AND (sqlc.narg(before_id)::bigint IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: PurgeAuthEvents :execrows
+DELETE FROM auth_events
+WHERE created_at < sqlc.arg(created_before)::timestamptz;
+
+-- name: ListVersionChain :many
+-- The versions rebuilding version upto: its closest snapshot and the patches
+-- after it, oldest first
+SELECT * FROM version
+WHERE object_id = sqlc.arg(object_id)
+  AND object_type = sqlc.arg(object_type)
+  AND version <= sqlc.arg(upto)
+  AND version >= (
+    SELECT COALESCE(MAX(s.version), 0) FROM version s
+    WHERE s.object_id = sqlc.arg(object_id)
+      AND s.object_type = sqlc.arg(object_type)
+      AND s.version <= sqlc.arg(upto)
+      AND s.kind = 'snapshot'
+  )
+ORDER BY version;
+
+-- name: ListVersions :many
+-- Newest first, walking idx_version_object_type_version_desc
+SELECT id, object_type, object_id, version, action, actor, created_at FROM version
+WHERE object_id = sqlc.arg(object_id)
+  AND object_type = sqlc.arg(object_type)
+  AND (sqlc.narg(before_version)::integer IS NULL OR version < sqlc.narg(before_version))
+  AND (sqlc.narg(action)::varchar IS NULL OR action = sqlc.narg(action))
+  AND (sqlc.narg(actor)::varchar IS NULL OR actor = sqlc.narg(actor))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR created_at < sqlc.narg(until))
+ORDER BY version DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: EnsureVersionPartitions :one
+SELECT ensure_version_partitions(sqlc.arg(months_ahead)::integer)::integer;
+
+-- name: ListVersionPartitions :many
+-- The monthly partitions of version, oldest first
+SELECT c.relname::text AS name
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = 'version'::regclass
+ORDER BY c.relname;
+
+-- name: ListPatchesAfter :many
+-- The first version from before on of the objects having older versions,
+-- when stored as a patch: it can't be rebuilt once the older ones are archived
+SELECT id, object_type, object_id, version, created_at FROM (
+    SELECT DISTINCT ON (object_type, object_id) id, object_type, object_id, version, kind, created_at
+    FROM version
+    WHERE created_at >= sqlc.arg(before)
+      AND (object_type, object_id) IN (
+        SELECT object_type, object_id FROM version WHERE created_at < sqlc.arg(before)
+      )
+    ORDER BY object_type, object_id, version
+) AS first_versions
+WHERE kind = 'patch';
+
+-- name: RebaseVersion :exec
+-- Stores a version as a snapshot, json being its full state
+UPDATE version
+SET json = $3,
+    kind = 'snapshot'
+WHERE id = $1
+  AND created_at = $2;
+
+-- name: LockVersionWrites :exec
+-- Taken by every version write until its transaction ends, so that versions
+-- aren't written while LockVersions is held
+SELECT pg_advisory_xact_lock_shared(hashtext('version'));
+
+-- name: LockVersions :exec
+-- Blocks the version writes until the transaction ends
+SELECT pg_advisory_xact_lock(hashtext('version'));
@@ -0,0 +1,55 @@
+// Package query parses the optional filters of the listing endpoints into
+// the nullable parameters of their queries
+package query
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Text is a text filter, unset when s is absent or empty
+func Text(s *string) pgtype.Text {
+	if s == nil || *s == "" {
+		return pgtype.Text{}
+	}
+	return pgtype.Text{String: *s, Valid: true}
+}
+
+// Time is an RFC 3339 timestamp filter, unset when s is absent or empty
+func Time(s *string) (pgtype.Timestamptz, error) {
+	if s == nil || *s == "" {
+		return pgtype.Timestamptz{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, *s)
+	if err != nil {
+		return pgtype.Timestamptz{}, err
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}, nil
+}
+
+// TimeRange parses the since and until bounds of a listing
+func TimeRange(since, until *string) (pgtype.Timestamptz, pgtype.Timestamptz, error) {
+	from, err := Time(since)
+	if err != nil {
+		return from, pgtype.Timestamptz{}, errors.New("invalid since, expected an RFC 3339 timestamp")
+	}
+	to, err := Time(until)
+	if err != nil {
+		return from, to, errors.New("invalid until, expected an RFC 3339 timestamp")
+	}
+	return from, to, nil
+}
+
+// Limit returns the page size of a listing, def when limit is absent
+func Limit(limit *int, def, max int32) (int32, error) {
+	if limit == nil {
+		return def, nil
+	}
+	if *limit < 1 || *limit > int(max) {
+		return def, fmt.Errorf("limit must be between 1 and %d", max)
+	}
+	return int32(*limit), nil
+}
@@ -0,0 +1,55 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRange(t *testing.T) {
+	since := "2026-01-02T15:04:05Z"
+	from, to, err := TimeRange(&since, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Valid || !from.Time.Equal(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)) || to.Valid {
+		t.Errorf("Expected only a lower bound, got %+v %+v", from, to)
+	}
+
+	badTime := "yesterday"
+	if _, _, err := TimeRange(nil, &badTime); err == nil {
+		t.Errorf("Expected an invalid until to be rejected")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		limit *int
+		want  int32
+		valid bool
+	}{
+		{nil, 50, true},
+		{ptr(10), 10, true},
+		{ptr(0), 0, false},
+		{ptr(501), 0, false},
+	}
+	for _, tt := range tests {
+		got, err := Limit(tt.limit, 50, 500)
+		if (err == nil) != tt.valid || (tt.valid && got != tt.want) {
+			t.Errorf("Expected %d (valid %v) for %v, got %d %v", tt.want, tt.valid, tt.limit, got, err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	empty, name := "", "alice"
+	if Text(nil).Valid || Text(&empty).Valid {
+		t.Errorf("Expected no filter for an absent or empty value")
+	}
+	if got := Text(&name); !got.Valid || got.String != "alice" {
+		t.Errorf("Expected a filter on alice, got %+v", got)
+	}
+}
+
+func ptr(n int) *int {
+	return &n
+}
@@ -0,0 +1,113 @@
+package replication
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"time"
+)
+
+// Document is implemented by the document types replicated to RxDB clients
+type Document interface {
+	GetID() string
+	IsDeleted() bool
+}
+
+// Repository is the persistence a replicated collection relies on.
+// R is the stored row type; lookups of a missing row return pgx.ErrNoRows.
+// Upsert writes deleted rows as tombstones in a single write.
+type Repository[R any] interface {
+	ListSinceCheckpoint(ctx context.Context, updatedAt time.Time, id string, limit int32) ([]R, error)
+	GetByIDForUpdate(ctx context.Context, id string) (R, error)
+	Create(ctx context.Context, row R) (R, error)
+	Upsert(ctx context.Context, row R) (R, error)
+}
+
+// RowMeta is the replication metadata of a stored row
+type RowMeta struct {
+	ID        string
+	UpdatedAt time.Time
+	// Revision in RxDB format: <height>-<hash>
+	Revision string
+	Deleted  bool
+}
+
+// Mapper converts the stored rows of a collection into documents and back
+type Mapper[R any, T Document] interface {
+	// ToDocument maps a stored row to the document sent to clients
+	ToDocument(row R) T
+	// FromDocument maps a pushed document to the row to store
+	FromDocument(doc T) R
+	// Meta exposes the replication metadata of a stored row
+	Meta(row R) RowMeta
+	// Equal reports whether two states of a document are the same,
+	// which is how conflicts with the assumed master state are detected
+	Equal(a, b T) bool
+	// Tombstone returns the deleted document of an id without row, e.g. a
+	// purged tombstone, as the master state of the pushes assuming one
+	Tombstone(id string) T
+}
+
+// Validator checks a pushed document before it gets written
+type Validator[T Document] func(doc T) error
+
+// AccessRule decides, document per document, what a principal may replicate.
+// Documents it can't read are left out of pulls and change streams; writes it
+// refuses are reported as errors with status 403.
+type AccessRule[T Document] interface {
+	CanRead(p *middleware.Principal, doc T) bool
+	// CanWrite is given the stored master state, nil when the document is created
+	CanWrite(p *middleware.Principal, doc T, master *T) bool
+}
+
+// Collection describes a replicated collection: R is its stored row type,
+// T the document type clients replicate.
+type Collection[R any, T Document] struct {
+	// Name of the collection, used in the endpoint path /<name>
+	Name string
+	// Event is the Socket.IO event streaming the changes, "<name>:sync" by default
+	Event string
+	// Repository binds the collection's repository to a connection or transaction
+	Repository func(conn db.DBTX) Repository[R]
+	Mapper     Mapper[R, T]
+	// Validator is optional
+	Validator Validator[T]
+	// Access is optional, every document is readable and writable without it
+	Access AccessRule[T]
+	// OnCreate is optional: it completes the documents a principal creates,
+	// once allowed by Access, e.g. with default values
+	OnCreate func(p *middleware.Principal, doc T) T
+	// ReadPermission and WritePermission are required to pull and push,
+	// "<name>:read" and "<name>:write" by default
+	ReadPermission  string
+	WritePermission string
+}
+
+func (c Collection[R, T]) event() string {
+	if c.Event != "" {
+		return c.Event
+	}
+	return c.Name + ":sync"
+}
+
+func (c Collection[R, T]) canRead(p *middleware.Principal, doc T) bool {
+	return c.Access == nil || c.Access.CanRead(p, doc)
+}
+
+func (c Collection[R, T]) canWrite(p *middleware.Principal, doc T, master *T) bool {
+	return c.Access == nil || c.Access.CanWrite(p, doc, master)
+}
+
+func (c Collection[R, T]) readPermission() string {
+	if c.ReadPermission != "" {
+		return c.ReadPermission
+	}
+	return c.Name + ":read"
+}
+
+func (c Collection[R, T]) writePermission() string {
+	if c.WritePermission != "" {
+		return c.WritePermission
+	}
+	return c.Name + ":write"
+}
@@ -0,0 +1,177 @@
+package replication
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/types"
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errForbidden reports a write refused by the collection's access rule
+var errForbidden = errors.New("forbidden")
+
+// beginner opens the transaction of a push batch, like pgxpool.Pool
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// push writes a whole push batch in a single transaction, so the batch is
+// either fully persisted or not at all. Each document runs in its own savepoint:
+// a failing one is reported in Errors and rolled back without aborting the others.
+// The written rows are returned so they are only broadcast once committed.
+func push[R any, T Document](ctx context.Context, pool beginner, col Collection[R, T], p *middleware.Principal, rows []types.RxReplicationWriteToMasterRow[T]) (types.ReplicationPushHandlerResult[T], []R, error) {
+	resp := types.ReplicationPushHandlerResult[T]{}
+	resp.Documents = make([]T, 0)
+	resp.Conflicts = make([]types.ReplicationConflict[T], 0)
+	resp.Errors = make([]types.ReplicationError, 0)
+	written := make([]R, 0, len(rows))
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return resp, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, row := range rows {
+		if col.Validator != nil {
+			if err := col.Validator(row.NewDocumentState); err != nil {
+				resp.Errors = append(resp.Errors, validationErrors(row.NewDocumentState.GetID(), err)...)
+				continue
+			}
+		}
+
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return resp, nil, err
+		}
+
+		stored, conflict, err := pushDocument(ctx, col.Repository(savepoint), col, p, row)
+		if err == nil && conflict == nil {
+			err = savepoint.Commit(ctx)
+		} else if rollbackErr := savepoint.Rollback(ctx); rollbackErr != nil {
+			return resp, nil, rollbackErr
+		}
+
+		switch {
+		case err != nil:
+			resp.Errors = append(resp.Errors, types.ReplicationError{
+				DocumentID: row.NewDocumentState.GetID(),
+				Error:      err.Error(),
+				Status:     writeErrorStatus(err),
+			})
+		case conflict != nil:
+			resp.Conflicts = append(resp.Conflicts, *conflict)
+		default:
+			written = append(written, stored)
+			resp.Documents = append(resp.Documents, col.Mapper.ToDocument(stored))
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return resp, nil, err
+	}
+	return resp, written, nil
+}
+
+// pushDocument writes one replicated document with the given (transactional) repository.
+// The stored row is locked and compared with the master state assumed by the
+// client: on mismatch nothing is written and the real master state is returned
+// as a conflict, like RxDB's replication protocol expects. The same goes when
+// the client sends the revision its change is based on and it is outdated, and
+// when it assumes the master state of a document without row, as a tombstone.
+// Writes refused by the collection's access rule fail with errForbidden.
+func pushDocument[R any, T Document](ctx context.Context, repo Repository[R], col Collection[R, T], p *middleware.Principal, row types.RxReplicationWriteToMasterRow[T]) (R, *types.ReplicationConflict[T], error) {
+	var stored R
+	doc := row.NewDocumentState
+	mapper := col.Mapper
+
+	existing, err := repo.GetByIDForUpdate(ctx, doc.GetID())
+	switch {
+	case err == nil:
+		master := mapper.ToDocument(existing)
+		if !col.canWrite(p, doc, &master) {
+			return stored, nil, errForbidden
+		}
+		outdated := row.PreviousRevision != "" && row.PreviousRevision != mapper.Meta(existing).Revision
+		if row.AssumedMasterState == nil || !mapper.Equal(*row.AssumedMasterState, master) || outdated {
+			return stored, &types.ReplicationConflict[T]{
+				DocumentID:       doc.GetID(),
+				NewDocumentState: doc,
+				RealMasterState:  master,
+			}, nil
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return stored, nil, err
+	case !col.canWrite(p, doc, nil):
+		return stored, nil, errForbidden
+	case row.AssumedMasterState != nil:
+		// Deleted meanwhile, its tombstone purged: the stale client must not
+		// recreate the document
+		return stored, &types.ReplicationConflict[T]{
+			DocumentID:       doc.GetID(),
+			NewDocumentState: doc,
+			RealMasterState:  mapper.Tombstone(doc.GetID()),
+		}, nil
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) && col.OnCreate != nil {
+		doc = col.OnCreate(p, doc)
+	}
+
+	// Like the JS server: a client assuming a master state edits the existing
+	// document, otherwise it creates a new one. A deleted document is upserted
+	// as a tombstone in one write, whether it was stored or not.
+	if row.AssumedMasterState != nil || doc.IsDeleted() {
+		stored, err = repo.Upsert(ctx, mapper.FromDocument(doc))
+	} else {
+		stored, err = repo.Create(ctx, mapper.FromDocument(doc))
+	}
+	return stored, nil, err
+}
+
+// validationErrors reports a document rejected by its collection's validator,
+// with one entry per invalid field when the validator tells which ones
+func validationErrors(documentID string, err error) []types.ReplicationError {
+	var fieldErrors types.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []types.ReplicationError{{
+			DocumentID: documentID,
+			Error:      err.Error(),
+			Status:     fiber.StatusUnprocessableEntity,
+		}}
+	}
+
+	result := make([]types.ReplicationError, len(fieldErrors))
+	for i, fieldError := range fieldErrors {
+		result[i] = types.ReplicationError{
+			DocumentID: documentID,
+			Error:      fieldError.Message,
+			Status:     fiber.StatusUnprocessableEntity,
+			Field:      fieldError.Field,
+		}
+	}
+	return result
+}
+
+// writeErrorStatus maps a failed write to an HTTP status: refused writes and
+// constraint violations (e.g. an email already taken) are the client's fault,
+// anything else is ours
+func writeErrorStatus(err error) int {
+	if errors.Is(err, errForbidden) {
+		return fiber.StatusForbidden
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return fiber.StatusConflict
+		case "23502", "23514", "22001": // not_null_violation, check_violation, string_data_right_truncation
+			return fiber.StatusUnprocessableEntity
+		}
+	}
+	return fiber.StatusInternalServerError
+}
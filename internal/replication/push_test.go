@@ -0,0 +1,373 @@
+package replication
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/types"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// testDoc is the document of the test collection, owned by a principal
+type testDoc struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (d testDoc) GetID() string   { return d.ID }
+func (d testDoc) IsDeleted() bool { return d.Deleted }
+
+// testRow is the stored row of the test collection
+type testRow struct {
+	testDoc
+	Revision  int
+	UpdatedAt time.Time
+}
+
+type testMapper struct{}
+
+func (testMapper) ToDocument(row testRow) testDoc   { return row.testDoc }
+func (testMapper) FromDocument(doc testDoc) testRow { return testRow{testDoc: doc} }
+func (testMapper) Equal(a, b testDoc) bool          { return a == b }
+func (testMapper) Tombstone(id string) testDoc      { return testDoc{ID: id, Deleted: true} }
+
+func (testMapper) Meta(row testRow) RowMeta {
+	return RowMeta{ID: row.ID, UpdatedAt: row.UpdatedAt, Revision: fmt.Sprintf("%d-test", row.Revision), Deleted: row.Deleted}
+}
+
+// ownerAccess lets principals replicate the documents they own only
+type ownerAccess struct{}
+
+func (ownerAccess) CanRead(p *middleware.Principal, doc testDoc) bool {
+	return doc.Owner == p.ID
+}
+
+func (ownerAccess) CanWrite(p *middleware.Principal, doc testDoc, master *testDoc) bool {
+	return doc.Owner == p.ID && (master == nil || master.Owner == p.ID)
+}
+
+// memoryDB holds the committed rows, like the pool of a push
+type memoryDB struct {
+	rows map[string]testRow
+}
+
+func (d *memoryDB) Begin(context.Context) (pgx.Tx, error) {
+	return &memoryTx{rows: maps.Clone(d.rows), commit: func(rows map[string]testRow) { d.rows = rows }}, nil
+}
+
+// memoryTx is a transaction or a savepoint over in-memory rows: its writes
+// reach its parent when committed only
+type memoryTx struct {
+	pgx.Tx
+	rows   map[string]testRow
+	commit func(map[string]testRow)
+	closed bool
+}
+
+func (tx *memoryTx) Begin(context.Context) (pgx.Tx, error) {
+	return &memoryTx{rows: maps.Clone(tx.rows), commit: func(rows map[string]testRow) { tx.rows = rows }}, nil
+}
+
+func (tx *memoryTx) Commit(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.commit(tx.rows)
+	return nil
+}
+
+func (tx *memoryTx) Rollback(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	return nil
+}
+
+// memoryRepository is the Repository of the test collection. Documents valued
+// "fail" are written, then fail like a violated constraint.
+type memoryRepository struct {
+	tx *memoryTx
+}
+
+func (r memoryRepository) ListSinceCheckpoint(context.Context, time.Time, string, int32) ([]testRow, error) {
+	return nil, nil
+}
+
+func (r memoryRepository) GetByIDForUpdate(_ context.Context, id string) (testRow, error) {
+	row, ok := r.tx.rows[id]
+	if !ok {
+		return testRow{}, pgx.ErrNoRows
+	}
+	return row, nil
+}
+
+func (r memoryRepository) Create(ctx context.Context, row testRow) (testRow, error) {
+	if _, ok := r.tx.rows[row.ID]; ok {
+		return testRow{}, &pgconn.PgError{Code: "23505"}
+	}
+	return r.Upsert(ctx, row)
+}
+
+func (r memoryRepository) Upsert(_ context.Context, row testRow) (testRow, error) {
+	row.Revision = r.tx.rows[row.ID].Revision + 1
+	row.UpdatedAt = time.Date(2026, 1, 1, 0, 0, row.Revision, 0, time.UTC)
+	r.tx.rows[row.ID] = row
+	if row.Value == "fail" {
+		return testRow{}, &pgconn.PgError{Code: "23514"}
+	}
+	return row, nil
+}
+
+func testCollection() Collection[testRow, testDoc] {
+	return Collection[testRow, testDoc]{
+		Name: "tests",
+		Repository: func(conn db.DBTX) Repository[testRow] {
+			return memoryRepository{tx: conn.(*memoryTx)}
+		},
+		Mapper: testMapper{},
+		Validator: func(doc testDoc) error {
+			if doc.Value == "" {
+				return types.ValidationErrors{{Field: "value", Message: "Required"}}
+			}
+			return nil
+		},
+		Access: ownerAccess{},
+	}
+}
+
+func testDB() *memoryDB {
+	return &memoryDB{rows: map[string]testRow{
+		"a": {testDoc: testDoc{ID: "a", Owner: "alice", Value: "one"}, Revision: 1},
+		"b": {testDoc: testDoc{ID: "b", Owner: "bob", Value: "one"}, Revision: 1},
+	}}
+}
+
+func TestPush(t *testing.T) {
+	alice := &middleware.Principal{ID: "alice"}
+	a := testDoc{ID: "a", Owner: "alice", Value: "one"}
+	b := testDoc{ID: "b", Owner: "bob", Value: "one"}
+	stale := testDoc{ID: "a", Owner: "alice", Value: "zero"}
+	staleB := testDoc{ID: "b", Owner: "bob", Value: "zero"}
+
+	tests := []struct {
+		name string
+		row  types.RxReplicationWriteToMasterRow[testDoc]
+		// status of the reported error, 0 when the document is written or conflicts
+		status   int
+		conflict bool
+		// stored is the row expected once the batch is committed
+		stored testRow
+	}{
+		{
+			name:   "create",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "c", Owner: "alice", Value: "new"}},
+			stored: testRow{testDoc: testDoc{ID: "c", Owner: "alice", Value: "new"}, Revision: 1},
+		},
+		{
+			name:   "update",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "two"}, AssumedMasterState: &a, PreviousRevision: "1-test"},
+			stored: testRow{testDoc: testDoc{ID: "a", Owner: "alice", Value: "two"}, Revision: 2},
+		},
+		{
+			name:   "deletion is a single write",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "one", Deleted: true}, AssumedMasterState: &a},
+			stored: testRow{testDoc: testDoc{ID: "a", Owner: "alice", Value: "one", Deleted: true}, Revision: 2},
+		},
+		{
+			name:   "deletion of an unknown document",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "c", Owner: "alice", Value: "gone", Deleted: true}},
+			stored: testRow{testDoc: testDoc{ID: "c", Owner: "alice", Value: "gone", Deleted: true}, Revision: 1},
+		},
+		{
+			name:     "stale assumed master state",
+			row:      types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "two"}, AssumedMasterState: &stale},
+			conflict: true,
+			stored:   testRow{testDoc: a, Revision: 1},
+		},
+		{
+			name:     "outdated previous revision",
+			row:      types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "two"}, AssumedMasterState: &a, PreviousRevision: "0-test"},
+			conflict: true,
+			stored:   testRow{testDoc: a, Revision: 1},
+		},
+		{
+			// Its tombstone was purged
+			name:     "update of a missing document",
+			row:      types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "c", Owner: "alice", Value: "two"}, AssumedMasterState: &testDoc{ID: "c", Owner: "alice", Value: "one"}},
+			conflict: true,
+		},
+		{
+			name:     "creation of an existing document",
+			row:      types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "two"}},
+			conflict: true,
+			stored:   testRow{testDoc: a, Revision: 1},
+		},
+		{
+			// The master state of a document the principal can't write isn't disclosed
+			name:   "access checked before conflicts",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "b", Owner: "alice", Value: "two"}, AssumedMasterState: &staleB},
+			status: fiber.StatusForbidden,
+			stored: testRow{testDoc: b, Revision: 1},
+		},
+		{
+			name:   "creation for another owner",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "c", Owner: "bob", Value: "new"}},
+			status: fiber.StatusForbidden,
+		},
+		{
+			name:   "invalid document",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice"}, AssumedMasterState: &a},
+			status: fiber.StatusUnprocessableEntity,
+			stored: testRow{testDoc: a, Revision: 1},
+		},
+		{
+			name:   "failed write rolled back",
+			row:    types.RxReplicationWriteToMasterRow[testDoc]{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "fail"}, AssumedMasterState: &a},
+			status: fiber.StatusUnprocessableEntity,
+			stored: testRow{testDoc: a, Revision: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDB()
+			resp, written, err := push(context.Background(), db, testCollection(), alice, []types.RxReplicationWriteToMasterRow[testDoc]{tt.row})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.status != 0:
+				if len(resp.Errors) != 1 || resp.Errors[0].Status != tt.status || len(written) != 0 {
+					t.Errorf("Expected an error with status %d, got %+v", tt.status, resp)
+				}
+			case tt.conflict:
+				master := testMapper{}.Tombstone(tt.row.NewDocumentState.ID)
+				if row, ok := db.rows[tt.row.NewDocumentState.ID]; ok {
+					master = row.testDoc
+				}
+				if len(resp.Conflicts) != 1 || resp.Conflicts[0].RealMasterState != master || len(written) != 0 {
+					t.Errorf("Expected a conflict with the master state %+v, got %+v", master, resp)
+				}
+			default:
+				if len(resp.Documents) != 1 || len(written) != 1 || written[0].Revision != tt.stored.Revision {
+					t.Errorf("Expected the document to be written once, got %+v %+v", resp, written)
+				}
+			}
+
+			stored := db.rows[tt.row.NewDocumentState.ID]
+			if stored.testDoc != tt.stored.testDoc || stored.Revision != tt.stored.Revision {
+				t.Errorf("Expected %+v to be stored, got %+v", tt.stored, stored)
+			}
+		})
+	}
+}
+
+func TestPushCompletesCreatedDocuments(t *testing.T) {
+	db := testDB()
+	alice := &middleware.Principal{ID: "alice"}
+	col := testCollection()
+	col.OnCreate = func(p *middleware.Principal, doc testDoc) testDoc {
+		doc.Value += " by " + p.ID
+		return doc
+	}
+	rows := []types.RxReplicationWriteToMasterRow[testDoc]{
+		{NewDocumentState: testDoc{ID: "c", Owner: "alice", Value: "new"}},
+		{NewDocumentState: testDoc{ID: "a", Owner: "alice", Value: "two"}, AssumedMasterState: &testDoc{ID: "a", Owner: "alice", Value: "one"}},
+	}
+
+	if _, _, err := push(context.Background(), db, col, alice, rows); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.rows["c"].Value; got != "new by alice" {
+		t.Errorf("Expected the created document completed, got %q", got)
+	}
+	if got := db.rows["a"].Value; got != "two" {
+		t.Errorf("Expected the updated document as pushed, got %q", got)
+	}
+}
+
+func TestPushRollsBackFailedDocumentsOnly(t *testing.T) {
+	db := testDB()
+	alice := &middleware.Principal{ID: "alice"}
+	rows := []types.RxReplicationWriteToMasterRow[testDoc]{
+		{NewDocumentState: testDoc{ID: "c", Owner: "alice", Value: "new"}},
+		{NewDocumentState: testDoc{ID: "d", Owner: "alice", Value: "fail"}},
+		{NewDocumentState: testDoc{ID: "e", Owner: "alice", Value: "new"}},
+	}
+
+	resp, written, err := push(context.Background(), db, testCollection(), alice, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 || len(resp.Errors) != 1 || resp.Errors[0].DocumentID != "d" {
+		t.Fatalf("Expected c and e written and d failed, got %+v", resp)
+	}
+	if _, ok := db.rows["d"]; ok {
+		t.Errorf("Expected the failed write of d to be rolled back")
+	}
+	if db.rows["c"].Revision != 1 || db.rows["e"].Revision != 1 {
+		t.Errorf("Expected c and e to be committed, got %+v", db.rows)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []types.ReplicationError
+	}{
+		{
+			name: "field errors",
+			err:  types.ValidationErrors{{Field: "email", Message: "Invalid email"}, {Field: "status", Message: "Required"}},
+			want: []types.ReplicationError{
+				{DocumentID: "a", Error: "Invalid email", Status: fiber.StatusUnprocessableEntity, Field: "email"},
+				{DocumentID: "a", Error: "Required", Status: fiber.StatusUnprocessableEntity, Field: "status"},
+			},
+		},
+		{
+			name: "plain error",
+			err:  errors.New("invalid document"),
+			want: []types.ReplicationError{{DocumentID: "a", Error: "invalid document", Status: fiber.StatusUnprocessableEntity}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validationErrors("a", tt.err); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errForbidden, fiber.StatusForbidden},
+		{fmt.Errorf("writing: %w", errForbidden), fiber.StatusForbidden},
+		{&pgconn.PgError{Code: "23505"}, fiber.StatusConflict},
+		{&pgconn.PgError{Code: "23502"}, fiber.StatusUnprocessableEntity},
+		{&pgconn.PgError{Code: "23514"}, fiber.StatusUnprocessableEntity},
+		{&pgconn.PgError{Code: "22001"}, fiber.StatusUnprocessableEntity},
+		{&pgconn.PgError{Code: "40001"}, fiber.StatusInternalServerError},
+		{errors.New("connection lost"), fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := writeErrorStatus(tt.err); got != tt.want {
+			t.Errorf("Expected %d for %v, got %d", tt.want, tt.err, got)
+		}
+	}
+}
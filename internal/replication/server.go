@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/types"
+	"log"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// Page size bounds for the pull endpoints
+const (
+	defaultPullLimit = 25
+	maxPullLimit     = 1000
+)
+
+// Server replicates the registered collections over HTTP and Socket.IO
+type Server struct {
+	pool   *pgxpool.Pool
+	events []stream
+
+	mu          sync.RWMutex
+	subscribers map[socket.SocketId]subscriber
+}
+
+// stream is the Socket.IO event of a collection, and the permission required
+// to receive it
+type stream struct {
+	event      string
+	permission string
+}
+
+// subscriber is a Socket.IO client receiving the change streams
+type subscriber struct {
+	client socketClient
+}
+
+// socketClient is what the change streams use of a *socket.Socket
+type socketClient interface {
+	Data() any
+	Emit(ev string, args ...any) error
+}
+
+// principal returns the current principal of the client, which changes when
+// it re-authenticates
+func (sub subscriber) principal() *middleware.Principal {
+	return middleware.GetSocketIOPrincipal(sub.client)
+}
+
+// NewServer creates a replication server
+func NewServer(pool *pgxpool.Pool) *Server {
+	return &Server{pool: pool, subscribers: map[socket.SocketId]subscriber{}}
+}
+
+// Register mounts the endpoints of a collection on router (which is expected
+// to be authenticated):
+//   - GET /<name>: checkpoint based pull, requiring the collection's read permission
+//   - POST /<name>: push, answering with the written documents, conflicts and errors,
+//     requiring the collection's write permission
+//
+// and broadcasts the pushed changes on the collection's Socket.IO event.
+func Register[R any, T Document](s *Server, router fiber.Router, col Collection[R, T]) {
+	s.events = append(s.events, stream{event: col.event(), permission: col.readPermission()})
+	router.Get("/"+col.Name, middleware.RequirePermission(col.readPermission()), pullHandler(s, col))
+	router.Post("/"+col.Name, middleware.RequirePermission(col.writePermission()), pushHandler(s, col))
+	log.Printf("🔁 Replicating collection %q (Socket.IO event %q)", col.Name, col.event())
+}
+
+// Subscribe streams to a newly connected client the changes of every collection
+// it can read, and tells it to pull them from its checkpoint again: events
+// emitted while it was disconnected (or before a server restart) are lost.
+// The client must carry its principal (see middleware.SetSocketIOPrincipal);
+// it is unsubscribed when it disconnects.
+func (s *Server) Subscribe(client *socket.Socket) {
+	p := middleware.GetSocketIOPrincipal(client)
+	s.mu.Lock()
+	s.subscribers[client.Id()] = subscriber{client: client}
+	s.mu.Unlock()
+	client.On("disconnect", func(...any) {
+		s.mu.Lock()
+		delete(s.subscribers, client.Id())
+		s.mu.Unlock()
+	})
+
+	for _, stream := range s.events {
+		if p.Can(stream.permission) {
+			client.Emit(stream.event, types.ResyncStreamEvent{Data: types.StreamResync})
+		}
+	}
+}
+
+// broadcast emits the written rows of a collection to each subscriber allowed
+// to read the collection, leaving out the documents its access rule hides.
+// The checkpoint is the one of the whole batch so that every client moves past it.
+func broadcast[R any, T Document](s *Server, col Collection[R, T], rows []R) {
+	documents := toDocumentData(col, rows)
+	checkpoint := latestCheckpoint(col, rows, types.CheckpointType{})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sub := range s.subscribers {
+		p := sub.principal()
+		if !p.Can(col.readPermission()) {
+			continue
+		}
+		readable := readableDocuments(col, p, documents)
+		if len(readable) == 0 {
+			continue
+		}
+		sub.client.Emit(col.event(), types.CollectionStreamEvent[T]{Data: types.RxReplicationPullStreamItem[T]{
+			Documents:  readable,
+			Checkpoint: checkpoint,
+		}})
+	}
+}
+
+// Publish broadcasts rows of a collection written outside of a push, like
+// Register does for pushes. The rows must be committed.
+func Publish[R any, T Document](s *Server, col Collection[R, T], rows ...R) {
+	if len(rows) > 0 {
+		broadcast(s, col, rows)
+	}
+}
+
+func pullHandler[R any, T Document](s *Server, col Collection[R, T]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		log.Printf("🚀 GET REQUEST ON /api/%s from user: %s", col.Name, middleware.GetUserIDFromContext(c))
+
+		var params types.GetCollectionParams
+		if err := c.QueryParser(&params); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid query parameters",
+			})
+		}
+
+		checkpoint := types.CheckpointType{}
+		if params.MinUpdatedAt != nil {
+			checkpoint.UpdatedAt = *params.MinUpdatedAt
+		}
+		if params.ID != nil {
+			checkpoint.ID = *params.ID
+		}
+		minUpdatedAt, err := checkpoint.Time()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid minUpdatedAt, expected an RFC 3339 timestamp",
+			})
+		}
+
+		limit := defaultPullLimit
+		if params.Limit != nil && *params.Limit > 0 {
+			limit = min(*params.Limit, maxPullLimit)
+		}
+
+		rows, err := col.Repository(s.pool).ListSinceCheckpoint(c.UserContext(), minUpdatedAt, checkpoint.ID, int32(limit))
+		if err != nil {
+			log.Printf("Pull of %s failed: %v", col.Name, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+
+		// The checkpoint covers the hidden documents too, or the client would pull them forever
+		return c.JSON(types.GetCollectionResponse[T]{
+			Documents:  readableDocuments(col, middleware.GetPrincipal(c), toDocumentData(col, rows)),
+			Checkpoint: latestCheckpoint(col, rows, checkpoint),
+		})
+	}
+}
+
+func pushHandler[R any, T Document](s *Server, col Collection[R, T]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		log.Printf("🚀 POST REQUEST ON /api/%s from user: %s", col.Name, middleware.GetUserIDFromContext(c))
+
+		var input types.PostCollectionBody[T]
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid JSON body",
+			})
+		}
+
+		resp, written, err := push(c.UserContext(), s.pool, col, middleware.GetPrincipal(c), input.Documents)
+		if err != nil {
+			log.Printf("Push of %d %s rolled back: %v", len(input.Documents), col.Name, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+
+		// 🔥 Emit update to all connected clients, now that the batch is committed
+		if len(written) > 0 {
+			broadcast(s, col, written)
+		}
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	}
+}
+
+// readableDocuments filters out the documents the principal can't read
+func readableDocuments[R any, T Document](col Collection[R, T], p *middleware.Principal, documents []types.RxDocumentData[T]) []types.RxDocumentData[T] {
+	if col.Access == nil {
+		return documents
+	}
+	result := make([]types.RxDocumentData[T], 0, len(documents))
+	for _, doc := range documents {
+		if col.canRead(p, doc.Document) {
+			result = append(result, doc)
+		}
+	}
+	return result
+}
+
+func toDocumentData[R any, T Document](col Collection[R, T], rows []R) []types.RxDocumentData[T] {
+	result := make([]types.RxDocumentData[T], len(rows))
+	for i, row := range rows {
+		meta := col.Mapper.Meta(row)
+		result[i] = types.RxDocumentData[T]{
+			Document: col.Mapper.ToDocument(row),
+			Rev:      meta.Revision,
+			Deleted:  meta.Deleted,
+			Meta: &types.RxDocumentMeta{
+				Lwt: meta.UpdatedAt.UnixMilli(),
+			},
+		}
+	}
+	return result
+}
+
+// latestCheckpoint returns the checkpoint of the most recently written row,
+// in the same (updated_at, id) order the pull endpoint pages through.
+// When there is no row, the given checkpoint is returned unchanged.
+func latestCheckpoint[R any, T Document](col Collection[R, T], rows []R, checkpoint types.CheckpointType) types.CheckpointType {
+	var latest *RowMeta
+	for _, row := range rows {
+		meta := col.Mapper.Meta(row)
+		if latest == nil || meta.UpdatedAt.After(latest.UpdatedAt) ||
+			(meta.UpdatedAt.Equal(latest.UpdatedAt) && meta.ID > latest.ID) {
+			latest = &meta
+		}
+	}
+	if latest == nil {
+		return checkpoint
+	}
+	return types.NewCheckpoint(latest.UpdatedAt, latest.ID)
+}
@@ -0,0 +1,106 @@
+package replication
+
+import (
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/types"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// memoryClient is a Socket.IO client recording the events it receives
+type memoryClient struct {
+	principal *middleware.Principal
+	events    []types.CollectionStreamEvent[testDoc]
+}
+
+func (c *memoryClient) Data() any {
+	return c.principal
+}
+
+func (c *memoryClient) Emit(_ string, args ...any) error {
+	c.events = append(c.events, args[0].(types.CollectionStreamEvent[testDoc]))
+	return nil
+}
+
+func TestLatestCheckpoint(t *testing.T) {
+	at := func(second int) time.Time { return time.Date(2026, 1, 1, 0, 0, second, 0, time.UTC) }
+	previous := types.NewCheckpoint(at(0), "z")
+
+	tests := []struct {
+		name string
+		rows []testRow
+		want types.CheckpointType
+	}{
+		{"no row", nil, previous},
+		{
+			"latest update",
+			[]testRow{{testDoc: testDoc{ID: "b"}, UpdatedAt: at(2)}, {testDoc: testDoc{ID: "a"}, UpdatedAt: at(1)}},
+			types.NewCheckpoint(at(2), "b"),
+		},
+		{
+			"same time, greatest id",
+			[]testRow{{testDoc: testDoc{ID: "b"}, UpdatedAt: at(1)}, {testDoc: testDoc{ID: "c"}, UpdatedAt: at(1)}, {testDoc: testDoc{ID: "a"}, UpdatedAt: at(1)}},
+			types.NewCheckpoint(at(1), "c"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestCheckpoint(testCollection(), tt.rows, previous); got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBroadcastFiltersPerSubscriber(t *testing.T) {
+	alice := &memoryClient{principal: &middleware.Principal{ID: "alice", Scopes: []string{"tests:read"}}}
+	bob := &memoryClient{principal: &middleware.Principal{ID: "bob", Scopes: []string{"tests:read"}}}
+	carol := &memoryClient{principal: &middleware.Principal{ID: "carol", Scopes: []string{"tests:read"}}}
+	// Owns a document, but isn't allowed to read the collection
+	dave := &memoryClient{principal: &middleware.Principal{ID: "dave"}}
+	s := &Server{subscribers: map[socket.SocketId]subscriber{
+		"alice": {client: alice},
+		"bob":   {client: bob},
+		"carol": {client: carol},
+		"dave":  {client: dave},
+		// Not authenticated
+		"anonymous": {client: &memoryClient{}},
+	}}
+
+	rows := []testRow{
+		{testDoc: testDoc{ID: "a", Owner: "alice"}, UpdatedAt: time.Date(2026, 1, 1, 0, 0, 1, 0, time.UTC)},
+		{testDoc: testDoc{ID: "b", Owner: "bob"}, UpdatedAt: time.Date(2026, 1, 1, 0, 0, 2, 0, time.UTC)},
+		{testDoc: testDoc{ID: "d", Owner: "dave"}, UpdatedAt: time.Date(2026, 1, 1, 0, 0, 3, 0, time.UTC)},
+	}
+	broadcast(s, testCollection(), rows)
+
+	for _, tt := range []struct {
+		client *memoryClient
+		ids    []string
+	}{
+		{alice, []string{"a"}},
+		{bob, []string{"b"}},
+		{carol, nil},
+		{dave, nil},
+	} {
+		if tt.ids == nil {
+			if len(tt.client.events) != 0 {
+				t.Errorf("Expected nothing sent to %s, got %+v", tt.client.principal.ID, tt.client.events)
+			}
+			continue
+		}
+		if len(tt.client.events) != 1 {
+			t.Fatalf("Expected one event for %s, got %+v", tt.client.principal.ID, tt.client.events)
+		}
+		item := tt.client.events[0].Data
+		if len(item.Documents) != len(tt.ids) || item.Documents[0].Document.ID != tt.ids[0] {
+			t.Errorf("Expected %v sent to %s, got %+v", tt.ids, tt.client.principal.ID, item.Documents)
+		}
+		// Everyone moves past the whole batch
+		if want := types.NewCheckpoint(rows[2].UpdatedAt, "d"); item.Checkpoint != want {
+			t.Errorf("Expected the checkpoint of the batch %+v, got %+v", want, item.Checkpoint)
+		}
+	}
+}
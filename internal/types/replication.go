@@ -0,0 +1,146 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CheckpointType represents a checkpoint for replication
+type CheckpointType struct {
+	UpdatedAt string `json:"updated_at"`
+	ID        string `json:"id"`
+}
+
+// NewCheckpoint builds the checkpoint pointing right after the given document
+func NewCheckpoint(updatedAt time.Time, id string) CheckpointType {
+	return CheckpointType{
+		UpdatedAt: updatedAt.UTC().Format(time.RFC3339Nano),
+		ID:        id,
+	}
+}
+
+// Time parses the checkpoint timestamp, an empty one meaning "from the start"
+func (c CheckpointType) Time() (time.Time, error) {
+	if c.UpdatedAt == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, c.UpdatedAt)
+}
+
+// GetCollectionParams represents parameters for pulling a collection
+// MinUpdatedAt and ID together form the checkpoint to resume the pull from
+type GetCollectionParams struct {
+	MinUpdatedAt *string `json:"minUpdatedAt,omitempty" query:"minUpdatedAt"`
+	ID           *string `json:"id,omitempty" query:"id"`
+	Limit        *int    `json:"limit,omitempty" query:"limit"`
+}
+
+// GetCollectionResponse represents the response for pulling a collection
+// Documents carry their RxDB revision so clients store the server's one
+type GetCollectionResponse[TCollection any] struct {
+	Documents  []RxDocumentData[TCollection] `json:"documents"`
+	Checkpoint CheckpointType                `json:"checkpoint"`
+}
+
+// PostCollectionBody represents the request body for creating/updating documents
+// This corresponds to RxReplicationWriteToMasterRow<TCollection>[] in TypeScript
+type PostCollectionBody[TCollection any] struct {
+	Documents []RxReplicationWriteToMasterRow[TCollection] `json:"documents"`
+}
+
+// PostCollectionResponse represents the response for creating/updating documents
+// This corresponds to PostCollectionResponse<TCollection> in TypeScript: RxDB's
+// push handler returns the realMasterState of its conflicts
+type PostCollectionResponse[TCollection any] = ReplicationPushHandlerResult[TCollection]
+
+// RxReplicationWriteToMasterRow represents a write operation to master
+type RxReplicationWriteToMasterRow[TCollection any] struct {
+	NewDocumentState   TCollection  `json:"newDocumentState"`
+	PreviousRevision   string       `json:"previousRevision"`
+	AssumedMasterState *TCollection `json:"assumedMasterState,omitempty"`
+}
+
+// ReplicationPushHandlerResult represents the result of a push operation
+type ReplicationPushHandlerResult[TCollection any] struct {
+	// Array of successfully processed documents
+	Documents []TCollection `json:"documents"`
+	// Array of conflicts that occurred during push
+	Conflicts []ReplicationConflict[TCollection] `json:"conflicts"`
+	// Errors that occurred during processing
+	Errors []ReplicationError `json:"errors"`
+}
+
+// ReplicationConflict represents a conflict during replication
+type ReplicationConflict[TCollection any] struct {
+	DocumentID       string      `json:"documentId"`
+	NewDocumentState TCollection `json:"newDocumentState"`
+	RealMasterState  TCollection `json:"realMasterState"`
+}
+
+// ReplicationError represents an error during replication
+type ReplicationError struct {
+	DocumentID string `json:"documentId"`
+	Error      string `json:"error"`
+	Status     int    `json:"status,omitempty"`
+	// Field is the path of the invalid field, for validation errors
+	Field string `json:"field,omitempty"`
+}
+
+// RxReplicationPullStreamItem represents an item in a pull stream from RxDB
+type RxReplicationPullStreamItem[TCollection any] struct {
+	Documents  []RxDocumentData[TCollection] `json:"documents"`
+	Checkpoint CheckpointType                `json:"checkpoint"`
+}
+
+// RxDocumentData represents a document with RxDB metadata
+// It is serialized flat, the RxDB fields next to the document ones
+type RxDocumentData[TCollection any] struct {
+	// The actual document data
+	Document TCollection
+	// RxDB metadata
+	Rev     string
+	Deleted bool
+	Meta    *RxDocumentMeta
+}
+
+// MarshalJSON adds the RxDB metadata fields to the JSON object of the document
+func (d RxDocumentData[TCollection]) MarshalJSON() ([]byte, error) {
+	raw, err := json.Marshal(d.Document)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]any{"_rev": d.Rev, "_deleted": d.Deleted}
+	if d.Meta != nil {
+		metadata["_meta"] = d.Meta
+	}
+	for name, value := range metadata {
+		if fields[name], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// RxDocumentMeta represents RxDB document metadata
+type RxDocumentMeta struct {
+	Lwt int64 `json:"lwt"` // Last write time
+}
+
+// StreamResync tells RxDB to go back to checkpoint iteration because
+// stream events may have been missed
+const StreamResync = "RESYNC"
+
+// ResyncStreamEvent is the stream event carrying StreamResync instead of documents
+type ResyncStreamEvent struct {
+	Data string `json:"data"`
+}
+
+// CollectionStreamEvent represents a stream event for a collection
+type CollectionStreamEvent[TCollection any] struct {
+	Data RxReplicationPullStreamItem[TCollection] `json:"data"`
+}
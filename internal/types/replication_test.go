@@ -0,0 +1,38 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRxDocumentData_MarshalJSON(t *testing.T) {
+	data := RxDocumentData[User]{
+		Document: User{ID: "user-1", Email: "user@example.com", Status: "active"},
+		Rev:      "2-abc",
+		Deleted:  true,
+		Meta:     &RxDocumentMeta{Lwt: 1700000000000},
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if fields["id"] != "user-1" || fields["email"] != "user@example.com" {
+		t.Errorf("Expected the document fields at the top level, got %s", raw)
+	}
+	if fields["_rev"] != "2-abc" {
+		t.Errorf("Expected _rev 2-abc, got %v", fields["_rev"])
+	}
+	if fields["_deleted"] != true {
+		t.Errorf("Expected _deleted to override the document value, got %v", fields["_deleted"])
+	}
+	if meta, ok := fields["_meta"].(map[string]any); !ok || meta["lwt"] != float64(1700000000000) {
+		t.Errorf("Expected _meta.lwt 1700000000000, got %v", fields["_meta"])
+	}
+}
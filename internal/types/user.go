@@ -13,56 +13,27 @@ type User struct {
 	Deleted   bool      `json:"_deleted"`
 }
 
-// GetUsersParams represents parameters for getting users
-type GetUsersParams struct {
-	MinUpdatedAt *string `json:"minUpdatedAt,omitempty"`
-	Limit        *int    `json:"limit,omitempty"`
-}
-
-// GetUsersResponse represents the response for getting users
-type GetUsersResponse struct {
-	Documents []User `json:"documents"`
+// GetID returns the primary key of the user document
+func (u User) GetID() string {
+	return u.ID
 }
 
-// PostUsersBody represents the request body for creating/updating users
-// This corresponds to RxReplicationWriteToMasterRow<User>[] in TypeScript
-type PostUsersBody struct {
-	Documents []RxReplicationWriteToMasterRow `json:"documents"`
+// IsDeleted reports whether the user document is a tombstone
+func (u User) IsDeleted() bool {
+	return u.Deleted
 }
 
-// PostUsersResponse represents the response for creating/updating users
-// This corresponds to ReplicationPushHandlerResult<User> in TypeScript
-type PostUsersResponse struct {
-	Documents ReplicationPushHandlerResult `json:"documents"`
-}
+// GetUsersParams represents parameters for getting users
+type GetUsersParams = GetCollectionParams
 
-// RxReplicationWriteToMasterRow represents a write operation to master
-type RxReplicationWriteToMasterRow struct {
-	NewDocumentState User   `json:"newDocumentState"`
-	PreviousRevision string `json:"previousRevision"`
-	AssumedMasterState *User `json:"assumedMasterState,omitempty"`
-}
+// GetUsersResponse represents the response for getting users
+type GetUsersResponse = GetCollectionResponse[User]
 
-// ReplicationPushHandlerResult represents the result of a push operation
-type ReplicationPushHandlerResult struct {
-	// Array of successfully processed documents
-	Documents []User `json:"documents,omitempty"`
-	// Array of conflicts that occurred during push
-	Conflicts []ReplicationConflict `json:"conflicts,omitempty"`
-	// Errors that occurred during processing
-	Errors []ReplicationError `json:"errors,omitempty"`
-}
+// PostUsersBody represents the request body for creating/updating users
+type PostUsersBody = PostCollectionBody[User]
 
-// ReplicationConflict represents a conflict during replication
-type ReplicationConflict struct {
-	DocumentID       string `json:"documentId"`
-	NewDocumentState User   `json:"newDocumentState"`
-	RealMasterState  User   `json:"realMasterState"`
-}
+// PostUsersResponse represents the response for creating/updating users
+type PostUsersResponse = PostCollectionResponse[User]
 
-// ReplicationError represents an error during replication
-type ReplicationError struct {
-	DocumentID string `json:"documentId"`
-	Error      string `json:"error"`
-	Status     int    `json:"status,omitempty"`
-}
+// UsersStreamEvent represents a stream event specifically for users
+type UsersStreamEvent = CollectionStreamEvent[User]
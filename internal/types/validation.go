@@ -0,0 +1,69 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+// FieldError is a validation failure of one document field
+type FieldError struct {
+	// Path of the field, like the zod issue path
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors lists every invalid field of a document
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// emailPattern is zod's email regex, minus its lookaheads (no leading dot,
+// no consecutive dots) which Go's regexp doesn't support and are checked apart
+var emailPattern = regexp.MustCompile(`(?i)^[A-Z0-9_'+\-.]*[A-Z0-9_+-]@([A-Z0-9][A-Z0-9\-]*\.)+[A-Z]{2,}$`)
+
+// Validate checks the user against the same rules as the zod userSchema,
+// returning ValidationErrors when some fields are invalid. The status is free
+// text there, stored in users.name, so any is accepted. On top of the schema,
+// pushed users need an id: it is the key of the replication.
+func (u User) Validate() error {
+	var errs ValidationErrors
+
+	if u.ID == "" {
+		errs = append(errs, FieldError{Field: "id", Message: "Required"})
+	}
+
+	switch {
+	case u.Email == "":
+		errs = append(errs, FieldError{Field: "email", Message: "Required"})
+	case !isEmail(u.Email):
+		errs = append(errs, FieldError{Field: "email", Message: "Invalid email"})
+	}
+
+	if u.CreatedAt.IsZero() {
+		errs = append(errs, FieldError{Field: "created_at", Message: "Required"})
+	}
+	if u.UpdatedAt.IsZero() {
+		errs = append(errs, FieldError{Field: "updated_at", Message: "Required"})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func isEmail(email string) bool {
+	return !strings.HasPrefix(email, ".") &&
+		!strings.Contains(email, "..") &&
+		emailPattern.MatchString(email)
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func validUser() User {
+	now := time.Now()
+	return User{
+		ID:        "user-1",
+		Email:     "user@example.com",
+		Status:    "active",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func TestUserValidate_Valid(t *testing.T) {
+	if err := validUser().Validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestUserValidate_AnyStatus(t *testing.T) {
+	// Statuses are the names of the existing users
+	user := validUser()
+	user.Status = "Alice Martin"
+	if err := user.Validate(); err != nil {
+		t.Errorf("Expected any status to be accepted, got %v", err)
+	}
+}
+
+func TestUserValidate_InvalidFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(u *User)
+		field  string
+	}{
+		{"empty id", func(u *User) { u.ID = "" }, "id"},
+		{"missing email", func(u *User) { u.Email = "" }, "email"},
+		{"email without domain", func(u *User) { u.Email = "user@" }, "email"},
+		{"email with leading dot", func(u *User) { u.Email = ".user@example.com" }, "email"},
+		{"email with consecutive dots", func(u *User) { u.Email = "us..er@example.com" }, "email"},
+		{"missing created_at", func(u *User) { u.CreatedAt = time.Time{} }, "created_at"},
+		{"missing updated_at", func(u *User) { u.UpdatedAt = time.Time{} }, "updated_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := validUser()
+			tt.mutate(&user)
+
+			var errs ValidationErrors
+			if err := user.Validate(); !errors.As(err, &errs) {
+				t.Fatalf("Expected ValidationErrors, got %v", err)
+			}
+			if len(errs) != 1 || errs[0].Field != tt.field {
+				t.Errorf("Expected a single error on %s, got %v", tt.field, errs)
+			}
+		})
+	}
+}
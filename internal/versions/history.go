@@ -0,0 +1,286 @@
+package versions
+
+import (
+	jsoncompare "cognyx/psychic-robot/json"
+	"cognyx/psychic-robot/middleware"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"cognyx/psychic-robot/query"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Page sizes of GET <path>/:id/versions
+const (
+	defaultVersionsLimit = 50
+	maxVersionsLimit     = 500
+)
+
+// Object describes a versioned object type whose history is served over HTTP
+type Object struct {
+	// Type is the object_type its versions are recorded with
+	Type string
+	// Path the objects are addressed by, e.g. "/users"
+	Path string
+	// ReadPermission is required to read the history
+	ReadPermission string
+	// CanRead decides, object per object, whether a principal may read its
+	// history. Optional, every history is readable without it.
+	CanRead func(p *middleware.Principal, id string) bool
+	// Restore writes the snapshot of v back as the current state of its object,
+	// returning the version recording it. Optional, versions can't be restored
+	// without it.
+	Restore func(ctx context.Context, v db.Version) (db.Version, error)
+	// RestorePermission is required to restore, on top of ReadPermission
+	RestorePermission string
+}
+
+// Version is a recorded version of an object
+type Version struct {
+	ObjectType string    `json:"object_type"`
+	ObjectID   string    `json:"object_id"`
+	Version    int32     `json:"version"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Snapshot is the state of the object, only sent for a single version
+	Snapshot json.RawMessage `json:"json,omitempty"`
+}
+
+// VersionsQuery are the filters of GET <path>/:id/versions, all optional
+type VersionsQuery struct {
+	Action *string `query:"action"`
+	Actor  *string `query:"actor"`
+	// Since and Until are RFC 3339 timestamps bounding the versions' time
+	Since *string `query:"since"`
+	Until *string `query:"until"`
+	// Before is the next_before of the previous page
+	Before *int32 `query:"before"`
+	Limit  *int   `query:"limit"`
+}
+
+// VersionsResponse is a page of the history of an object, newest versions first
+type VersionsResponse struct {
+	Versions []Version `json:"versions"`
+	// NextBefore fetches the next page as ?before=, absent on the last page
+	NextBefore *int32 `json:"next_before,omitempty"`
+}
+
+// History serves the versions of an object type
+type History struct {
+	obj  Object
+	repo repository.VersionRepository
+}
+
+// Register mounts the history of obj on router, which must be authenticated:
+//   - GET <path>/:id/versions: lists the versions of an object matching the
+//     VersionsQuery filters, without their snapshot
+//   - GET <path>/:id/versions/:n: returns version n with its snapshot
+//   - GET <path>/:id/versions/:a/diff/:b: the changes from version a to b, in
+//     the format of the format query parameter: json-patch (RFC 6902, by
+//     default), merge-patch (RFC 7386) or nsf (human-readable)
+//   - POST <path>/:id/versions/:n/restore: writes the snapshot of version n
+//     back, answering with the new version. Only mounted when the object can
+//     be restored, and also requiring its restore permission.
+//
+// All require the object's read permission.
+func Register(router fiber.Router, pool *pgxpool.Pool, obj Object) *History {
+	h := &History{obj: obj, repo: repository.NewVersionRepository(db.New(pool))}
+	versions := router.Group(obj.Path+"/:id/versions", middleware.RequirePermission(obj.ReadPermission), h.authorize)
+	versions.Get("/", h.listHandler)
+	versions.Get("/:n", h.getHandler)
+	versions.Get("/:a/diff/:b", h.diffHandler)
+	if obj.Restore != nil {
+		versions.Post("/:n/restore", middleware.RequirePermission(obj.RestorePermission), h.restoreHandler)
+	}
+	return h
+}
+
+// authorize applies the Object's access rule to the requested object
+func (h *History) authorize(c *fiber.Ctx) error {
+	if h.obj.CanRead != nil && !h.obj.CanRead(middleware.GetPrincipal(c), c.Params("id")) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions",
+		})
+	}
+	return c.Next()
+}
+
+func (h *History) listHandler(c *fiber.Ctx) error {
+	var query VersionsQuery
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters",
+		})
+	}
+	filter, err := query.filter()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	filter.ObjectType = h.obj.Type
+	filter.ObjectID = c.Params("id")
+
+	rows, err := h.repo.List(c.UserContext(), filter)
+	if err != nil {
+		log.Printf("Listing %s versions failed: %v", h.obj.Type, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	resp := VersionsResponse{Versions: make([]Version, 0, len(rows))}
+	for _, row := range rows {
+		resp.Versions = append(resp.Versions, Version{
+			ObjectType: row.ObjectType,
+			ObjectID:   row.ObjectID,
+			Version:    row.Version,
+			Action:     row.Action,
+			Actor:      row.Actor,
+			CreatedAt:  row.CreatedAt,
+		})
+	}
+	if len(rows) == int(filter.RowLimit) {
+		resp.NextBefore = &rows[len(rows)-1].Version
+	}
+	return c.JSON(resp)
+}
+
+func (h *History) getHandler(c *fiber.Ctx) error {
+	n, err := versionParam(c.Params("n"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	v, err := h.repo.Get(c.UserContext(), h.obj.Type, c.Params("id"), n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Version not found"})
+	}
+	if err != nil {
+		log.Printf("Getting %s version failed: %v", h.obj.Type, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	return c.JSON(NewVersion(v))
+}
+
+func (h *History) diffHandler(c *fiber.Ctx) error {
+	format, err := jsoncompare.ParseDiffFormat(c.Query("format"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var snapshots [2][]byte
+	for i, param := range []string{"a", "b"} {
+		n, err := versionParam(c.Params(param))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		v, err := h.repo.Get(c.UserContext(), h.obj.Type, c.Params("id"), n)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": fmt.Sprintf("Version %d not found", n),
+			})
+		}
+		if err != nil {
+			log.Printf("Getting %s version failed: %v", h.obj.Type, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+		snapshots[i] = v.Json
+	}
+
+	diff, err := jsoncompare.Diff(snapshots[0], snapshots[1], format)
+	if err != nil {
+		log.Printf("Diffing %s %s versions failed: %v", h.obj.Type, c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Diff error"})
+	}
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	return c.Send(diff)
+}
+
+func (h *History) restoreHandler(c *fiber.Ctx) error {
+	n, err := versionParam(c.Params("n"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	v, err := h.repo.Get(c.UserContext(), h.obj.Type, c.Params("id"), n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Version not found"})
+	}
+	if err != nil {
+		log.Printf("Getting %s version failed: %v", h.obj.Type, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	restored, err := h.obj.Restore(c.UserContext(), v)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "the version conflicts with the current data: " + pgErr.Detail,
+		})
+	}
+	if err != nil {
+		log.Printf("Restoring %s %s to version %d failed: %v", h.obj.Type, v.ObjectID, n, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	log.Printf("⏪ %s %s restored to version %d by %s", h.obj.Type, v.ObjectID, n, middleware.GetUserIDFromContext(c))
+	return c.Status(fiber.StatusCreated).JSON(NewVersion(restored))
+}
+
+// NewVersion converts a stored version, snapshot included
+func NewVersion(v db.Version) Version {
+	return Version{
+		ObjectType: v.ObjectType,
+		ObjectID:   v.ObjectID,
+		Version:    v.Version,
+		Action:     v.Action,
+		Actor:      v.Actor,
+		CreatedAt:  v.CreatedAt,
+		Snapshot:   v.Json,
+	}
+}
+
+// versionParam parses a version number from the path
+func versionParam(s string) (int32, error) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid version %q, expected a number from 1", s)
+	}
+	return int32(n), nil
+}
+
+// filter validates the query into the parameters of the ListVersions query,
+// object aside
+func (q VersionsQuery) filter() (db.ListVersionsParams, error) {
+	filter := db.ListVersionsParams{
+		Action: query.Text(q.Action),
+		Actor:  query.Text(q.Actor),
+	}
+	if q.Before != nil {
+		filter.BeforeVersion = pgtype.Int4{Int32: *q.Before, Valid: true}
+	}
+
+	var err error
+	if filter.RowLimit, err = query.Limit(q.Limit, defaultVersionsLimit, maxVersionsLimit); err != nil {
+		return filter, err
+	}
+	filter.Since, filter.Until, err = query.TimeRange(q.Since, q.Until)
+	return filter, err
+}
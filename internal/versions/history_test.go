@@ -0,0 +1,224 @@
+package versions
+
+import (
+	"cognyx/psychic-robot/persistence/db"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// memoryVersions is an in-memory repository.VersionRepository
+type memoryVersions struct {
+	versions []db.Version
+	filter   db.ListVersionsParams
+}
+
+func (m *memoryVersions) Get(_ context.Context, objectType, objectID string, version int32) (db.Version, error) {
+	for _, v := range m.versions {
+		if v.ObjectType == objectType && v.ObjectID == objectID && v.Version == version {
+			return v, nil
+		}
+	}
+	return db.Version{}, pgx.ErrNoRows
+}
+
+func (m *memoryVersions) List(_ context.Context, filter db.ListVersionsParams) ([]db.ListVersionsRow, error) {
+	m.filter = filter
+	var rows []db.ListVersionsRow
+	for i := len(m.versions) - 1; i >= 0 && len(rows) < int(filter.RowLimit); i-- {
+		v := m.versions[i]
+		if filter.BeforeVersion.Valid && v.Version >= filter.BeforeVersion.Int32 {
+			continue
+		}
+		rows = append(rows, db.ListVersionsRow{ObjectType: v.ObjectType, ObjectID: v.ObjectID, Version: v.Version, Action: v.Action, Actor: v.Actor})
+	}
+	return rows, nil
+}
+
+func testHistory(t *testing.T) (*fiber.App, *memoryVersions) {
+	repo := &memoryVersions{}
+	for n := int32(1); n <= 3; n++ {
+		repo.versions = append(repo.versions, db.Version{
+			ObjectType: "user",
+			ObjectID:   "alice",
+			Version:    n,
+			Action:     "update",
+			Actor:      "admin-1",
+			Json:       []byte(`{"id":"alice"}`),
+		})
+	}
+	h := &History{obj: Object{Type: "user"}, repo: repo}
+	app := fiber.New()
+	app.Get("/users/:id/versions", h.listHandler)
+	app.Get("/users/:id/versions/:n", h.getHandler)
+	return app, repo
+}
+
+func get(t *testing.T, app *fiber.App, url string, out any) int {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == fiber.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestListVersionsPages(t *testing.T) {
+	app, repo := testHistory(t)
+
+	var page VersionsResponse
+	if status := get(t, app, "/users/alice/versions?limit=2&actor=admin-1", &page); status != fiber.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if repo.filter.ObjectID != "alice" || repo.filter.ObjectType != "user" || repo.filter.Actor.String != "admin-1" {
+		t.Errorf("Expected the versions of user alice by admin-1, got %+v", repo.filter)
+	}
+	if len(page.Versions) != 2 || page.Versions[0].Version != 3 || page.NextBefore == nil || *page.NextBefore != 2 {
+		t.Fatalf("Expected versions 3 and 2 and a next page, got %+v", page)
+	}
+	if page.Versions[0].Snapshot != nil {
+		t.Errorf("Expected no snapshot in the listing, got %s", page.Versions[0].Snapshot)
+	}
+
+	var last VersionsResponse
+	get(t, app, "/users/alice/versions?limit=2&before=2", &last)
+	if len(last.Versions) != 1 || last.Versions[0].Version != 1 || last.NextBefore != nil {
+		t.Errorf("Expected version 1 on the last page, got %+v", last)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	app, _ := testHistory(t)
+
+	var v Version
+	if status := get(t, app, "/users/alice/versions/2", &v); status != fiber.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if v.Version != 2 || string(v.Snapshot) != `{"id":"alice"}` {
+		t.Errorf("Expected version 2 with its snapshot, got %+v", v)
+	}
+	if status := get(t, app, "/users/alice/versions/9", nil); status != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for a missing version, got %d", status)
+	}
+	if status := get(t, app, "/users/alice/versions/zero", nil); status != fiber.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid version, got %d", status)
+	}
+}
+
+func TestVersionsQueryFilter(t *testing.T) {
+	action := "delete"
+	since := "2026-01-02T15:04:05Z"
+	filter, err := VersionsQuery{Action: &action, Since: &since}.filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Action.Valid || filter.Action.String != "delete" || filter.Actor.Valid {
+		t.Errorf("Expected only an action filter, got %+v", filter)
+	}
+	if !filter.Since.Valid || !filter.Since.Time.Equal(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)) || filter.Until.Valid {
+		t.Errorf("Expected only a lower time bound, got %+v", filter)
+	}
+	if filter.RowLimit != defaultVersionsLimit || filter.BeforeVersion.Valid {
+		t.Errorf("Expected the default first page, got %+v", filter)
+	}
+
+	badTime := "yesterday"
+	tooMany := maxVersionsLimit + 1
+	for _, query := range []VersionsQuery{{Until: &badTime}, {Limit: &tooMany}} {
+		if _, err := query.filter(); err == nil {
+			t.Errorf("Expected %+v to be rejected", query)
+		}
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	repo := &memoryVersions{versions: []db.Version{{ObjectType: "user", ObjectID: "alice", Version: 1, Json: []byte(`{"id":"alice"}`)}}}
+	var restoreErr error
+	var restored []db.Version
+	h := &History{repo: repo, obj: Object{
+		Type: "user",
+		Restore: func(_ context.Context, v db.Version) (db.Version, error) {
+			restored = append(restored, v)
+			return db.Version{ObjectType: v.ObjectType, ObjectID: v.ObjectID, Version: 2, Action: "restore", Json: v.Json}, restoreErr
+		},
+	}}
+	app := fiber.New()
+	app.Post("/users/:id/versions/:n/restore", h.restoreHandler)
+	post := func(url string) *http.Response {
+		resp, err := app.Test(httptest.NewRequest("POST", url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post("/users/alice/versions/1/restore")
+	var v Version
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusCreated || v.Version != 2 || v.Action != "restore" {
+		t.Errorf("Expected version 2 restoring version 1, got %d %+v", resp.StatusCode, v)
+	}
+	if len(restored) != 1 || restored[0].Version != 1 {
+		t.Errorf("Expected version 1 to be restored, got %+v", restored)
+	}
+
+	if resp := post("/users/alice/versions/5/restore"); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for a missing version, got %d", resp.StatusCode)
+	}
+	restoreErr = &pgconn.PgError{Code: "23505", Detail: "Key (email) already exists."}
+	if resp := post("/users/alice/versions/1/restore"); resp.StatusCode != fiber.StatusConflict {
+		t.Errorf("Expected 409 when the email is taken since, got %d", resp.StatusCode)
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	repo := &memoryVersions{versions: []db.Version{
+		{ObjectType: "user", ObjectID: "alice", Version: 1, Json: []byte(`{"id":"alice","name":"Alice"}`)},
+		{ObjectType: "user", ObjectID: "alice", Version: 2, Json: []byte(`{"id":"alice","name":"Alicia"}`)},
+	}}
+	h := &History{obj: Object{Type: "user"}, repo: repo}
+	app := fiber.New()
+	app.Get("/users/:id/versions/:a/diff/:b", h.diffHandler)
+
+	tests := []struct {
+		url         string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/users/alice/versions/1/diff/2", fiber.StatusOK, "application/json-patch+json", `[{"value":"Alicia","op":"replace","path":"/name"}]`},
+		{"/users/alice/versions/2/diff/1?format=merge-patch", fiber.StatusOK, "application/merge-patch+json", `{"name":"Alice"}`},
+		{"/users/alice/versions/1/diff/3", fiber.StatusNotFound, "", ""},
+		{"/users/alice/versions/1/diff/2?format=unified", fiber.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("Expected %d for %s, got %d", tt.status, tt.url, resp.StatusCode)
+			continue
+		}
+		if tt.body != "" && (string(body) != tt.body || resp.Header.Get("Content-Type") != tt.contentType) {
+			t.Errorf("Expected %s %s for %s, got %s %s", tt.contentType, tt.body, tt.url, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package versions
+
+import (
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/repository"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultPartitionsAhead is how many months of partitions are created ahead
+const DefaultPartitionsAhead = 3
+
+// DefaultRetentionInterval is how often the partitions are maintained
+const DefaultRetentionInterval = 24 * time.Hour
+
+// partitionPrefix and partitionLayout name the monthly partitions, version_YYYY_MM
+const (
+	partitionPrefix = "version_"
+	partitionLayout = "2006_01"
+)
+
+// Retention keeps the version table small: it creates its monthly partitions
+// ahead, and archives the ones older than the retention to gzipped NDJSON
+// files before dropping them
+type Retention struct {
+	repo repository.VersionPartitionRepository
+	// dir receives the <partition>.ndjson.gz archives
+	dir string
+	// months of versions kept in the table besides the current one, 0 keeps everything
+	months int
+	now    func() time.Time
+}
+
+// ArchivedVersion is a line of a version archive
+type ArchivedVersion struct {
+	ID         int64     `json:"id"`
+	ObjectType string    `json:"object_type"`
+	ObjectID   string    `json:"object_id"`
+	Version    int32     `json:"version"`
+	Kind       string    `json:"kind"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Json is the snapshot or the patch, as stored
+	Json json.RawMessage `json:"json"`
+}
+
+// NewRetention creates the retention of the versions, archived into dir after
+// months months
+func NewRetention(pool *pgxpool.Pool, dir string, months int) *Retention {
+	return &Retention{repo: repository.NewVersionPartitionRepository(pool), dir: dir, months: months, now: time.Now}
+}
+
+// EnsurePartitions creates the missing partitions of the current month and of
+// the DefaultPartitionsAhead next ones. Versions can't be written outside of them.
+func (r *Retention) EnsurePartitions(ctx context.Context) error {
+	created, err := r.repo.EnsurePartitions(ctx, DefaultPartitionsAhead)
+	if err != nil {
+		return fmt.Errorf("creating the version partitions: %w", err)
+	}
+	if created > 0 {
+		log.Printf("🗂️ Created %d version partitions", created)
+	}
+	return nil
+}
+
+// Run maintains the partitions every interval until ctx is done
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Maintain(ctx); err != nil {
+			log.Printf("Version retention failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain creates the partitions ahead, then archives the partitions of the
+// months before the retention, oldest first. A partition is only dropped
+// once its archive is written.
+func (r *Retention) Maintain(ctx context.Context) error {
+	if err := r.EnsurePartitions(ctx); err != nil {
+		return err
+	}
+	if r.months <= 0 {
+		return nil
+	}
+
+	partitions, err := r.repo.ListPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("listing the version partitions: %w", err)
+	}
+	now := r.now().UTC()
+	cutoff := time.Date(now.Year(), now.Month()-time.Month(r.months), 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range partitions {
+		month, ok := partitionMonth(name)
+		if !ok || !month.Before(cutoff) {
+			continue
+		}
+		if err := r.archive(ctx, name, month.AddDate(0, 1, 0)); err != nil {
+			return fmt.Errorf("archiving %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// archive writes the partition into <dir>/<name>.ndjson.gz and drops it. The
+// versions from end on that patch its versions are stored as snapshots first.
+// Only the rebase and the drop block the version writes: the partition of a
+// past month isn't written anymore, so it is exported meanwhile. The versions
+// written since the first rebase, which may patch versions of the partition,
+// are rebased again before the drop.
+func (r *Retention) archive(ctx context.Context, name string, end time.Time) error {
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, name+".ndjson.gz")
+
+	err := r.repo.LockVersions(ctx, func(repo repository.VersionPartitionRepository) error {
+		return r.rebase(ctx, repo, name, end)
+	})
+	if err != nil {
+		return err
+	}
+
+	count, err := r.export(ctx, r.repo, name, path)
+	if err != nil {
+		return err
+	}
+
+	return r.repo.LockVersions(ctx, func(repo repository.VersionPartitionRepository) error {
+		if err := r.rebase(ctx, repo, name, end); err != nil {
+			return err
+		}
+		if err := repo.DropPartition(ctx, name); err != nil {
+			return err
+		}
+		log.Printf("📦 Archived %d versions of %s to %s", count, name, path)
+		return nil
+	})
+}
+
+// rebase stores as snapshots the versions from end on patching versions of
+// the partition
+func (r *Retention) rebase(ctx context.Context, repo repository.VersionPartitionRepository, name string, end time.Time) error {
+	rebased, err := repo.RebasePatches(ctx, end)
+	if err != nil {
+		return err
+	}
+	if rebased > 0 {
+		log.Printf("Stored %d versions following %s as snapshots", rebased, name)
+	}
+	return nil
+}
+
+// export writes the versions of the partition to path, through a temporary
+// file so that a failed export never leaves a truncated archive
+func (r *Retention) export(ctx context.Context, repo repository.VersionPartitionRepository, name, path string) (int, error) {
+	tmp, err := os.CreateTemp(r.dir, name+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	count := 0
+	err = repo.ExportPartition(ctx, name, func(v db.Version) error {
+		count++
+		return enc.Encode(ArchivedVersion{
+			ID:         v.ID,
+			ObjectType: v.ObjectType,
+			ObjectID:   v.ObjectID,
+			Version:    v.Version,
+			Kind:       v.Kind,
+			Action:     v.Action,
+			Actor:      v.Actor,
+			CreatedAt:  v.CreatedAt,
+			Json:       v.Json,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return count, os.Rename(tmp.Name(), path)
+}
+
+// partitionMonth returns the first day of the month of a version_YYYY_MM
+// partition, at midnight UTC like its lower bound
+func partitionMonth(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, partitionPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	month, err := time.ParseInLocation(partitionLayout, suffix, time.UTC)
+	return month, err == nil
+}
@@ -0,0 +1,247 @@
+package versions
+
+import (
+	"bufio"
+	"cognyx/psychic-robot/persistence/db"
+	"cognyx/psychic-robot/persistence/dbtest"
+	"cognyx/psychic-robot/persistence/repository"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryPartitions is an in-memory repository.VersionPartitionRepository
+type memoryPartitions struct {
+	partitions map[string][]db.Version
+	ensured    int32
+	rebased    []time.Time
+	dropped    []string
+	exportErr  error
+
+	// versions is the lock of LockVersions, shared by write
+	versions sync.RWMutex
+	onRebase func()
+	mu       sync.Mutex
+	events   []string
+}
+
+func (m *memoryPartitions) record(event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+// write is a version write, like createVersion
+func (m *memoryPartitions) write() {
+	m.versions.RLock()
+	defer m.versions.RUnlock()
+	m.record("write")
+}
+
+func (m *memoryPartitions) LockVersions(_ context.Context, fn func(repository.VersionPartitionRepository) error) error {
+	m.versions.Lock()
+	defer m.versions.Unlock()
+	return fn(m)
+}
+
+func (m *memoryPartitions) EnsurePartitions(_ context.Context, monthsAhead int32) (int32, error) {
+	m.ensured = monthsAhead
+	return 0, nil
+}
+
+func (m *memoryPartitions) ListPartitions(context.Context) ([]string, error) {
+	names := []string{"version_2026_01", "version_2026_02", "version_2026_03", "version_2026_04", "version_2026_05"}
+	return names, nil
+}
+
+func (m *memoryPartitions) RebasePatches(_ context.Context, before time.Time) (int, error) {
+	m.rebased = append(m.rebased, before)
+	m.record("rebase")
+	if m.onRebase != nil {
+		m.onRebase()
+	}
+	return 0, nil
+}
+
+func (m *memoryPartitions) ExportPartition(_ context.Context, name string, emit func(db.Version) error) error {
+	if !m.versions.TryRLock() {
+		return errors.New("exported while blocking the version writes")
+	}
+	m.versions.RUnlock()
+	for _, v := range m.partitions[name] {
+		if err := emit(v); err != nil {
+			return err
+		}
+	}
+	return m.exportErr
+}
+
+func (m *memoryPartitions) DropPartition(_ context.Context, name string) error {
+	m.dropped = append(m.dropped, name)
+	m.record("drop")
+	return nil
+}
+
+func testRetention(t *testing.T, months int) (*Retention, *memoryPartitions) {
+	repo := &memoryPartitions{partitions: map[string][]db.Version{
+		"version_2026_01": {
+			{ID: 1, ObjectType: "user", ObjectID: "alice", Version: 1, Kind: "snapshot", Action: "create", Json: []byte(`{"id":"alice"}`)},
+			{ID: 2, ObjectType: "user", ObjectID: "alice", Version: 2, Kind: "patch", Action: "update", Json: []byte(`[{"op":"add","path":"/name","value":"Alice"}]`)},
+		},
+	}}
+	now := func() time.Time { return time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC) }
+	return &Retention{repo: repo, dir: t.TempDir(), months: months, now: now}, repo
+}
+
+func TestRetentionArchivesOldPartitions(t *testing.T) {
+	r, repo := testRetention(t, 2)
+	if err := r.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.ensured != DefaultPartitionsAhead {
+		t.Errorf("Expected %d months of partitions ahead, got %d", DefaultPartitionsAhead, repo.ensured)
+	}
+	if len(repo.dropped) != 2 || repo.dropped[0] != "version_2026_01" || repo.dropped[1] != "version_2026_02" {
+		t.Fatalf("Expected the partitions before March to be dropped, got %v", repo.dropped)
+	}
+	// Before the export, then before the drop
+	if len(repo.rebased) != 4 || !repo.rebased[1].Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the versions following each partition to be rebased twice, got %v", repo.rebased)
+	}
+
+	f, err := os.Open(filepath.Join(r.dir, "version_2026_01.ndjson.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived []ArchivedVersion
+	lines := bufio.NewScanner(zr)
+	for lines.Scan() {
+		var v ArchivedVersion
+		if err := json.Unmarshal(lines.Bytes(), &v); err != nil {
+			t.Fatal(err)
+		}
+		archived = append(archived, v)
+	}
+	if len(archived) != 2 || archived[1].Kind != "patch" || string(archived[0].Json) != `{"id":"alice"}` {
+		t.Errorf("Expected both versions of alice as stored, got %+v", archived)
+	}
+}
+
+func TestRetentionKeepsPartitionOnFailedExport(t *testing.T) {
+	r, repo := testRetention(t, 2)
+	repo.exportErr = errors.New("connection lost")
+
+	if err := r.Maintain(context.Background()); err == nil {
+		t.Fatalf("Expected the failed export to be reported")
+	}
+	if len(repo.dropped) != 0 {
+		t.Errorf("Expected no partition dropped, got %v", repo.dropped)
+	}
+	if entries, _ := os.ReadDir(r.dir); len(entries) != 0 {
+		t.Errorf("Expected no archive left behind, got %v", entries)
+	}
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	r, repo := testRetention(t, 0)
+	if err := r.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if repo.ensured != DefaultPartitionsAhead || len(repo.dropped) != 0 {
+		t.Errorf("Expected only the partitions ahead to be created, got %+v", repo)
+	}
+}
+
+func TestRetentionCutoffInUTC(t *testing.T) {
+	r, repo := testRetention(t, 2)
+	// Still April 30 in UTC
+	r.now = func() time.Time { return time.Date(2026, 5, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)) }
+	if err := r.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.dropped) != 1 || repo.dropped[0] != "version_2026_01" {
+		t.Errorf("Expected only the January partition to be dropped, got %v", repo.dropped)
+	}
+	if len(repo.rebased) != 2 || repo.rebased[0].Location() != time.UTC {
+		t.Errorf("Expected the versions to be rebased from midnight UTC, got %v", repo.rebased)
+	}
+}
+
+func TestRetentionBlocksWritesWhileRebasing(t *testing.T) {
+	r, repo := testRetention(t, 3)
+	var wg sync.WaitGroup
+	repo.onRebase = func() {
+		// A write following the rebase could patch a version of the partition:
+		// the one during the export is rebased before the drop
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.write()
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := r.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	want := []string{"rebase", "write", "rebase", "drop", "write"}
+	if fmt.Sprint(repo.events) != fmt.Sprint(want) {
+		t.Errorf("Expected the writes to wait for the rebases and the drop %v, got %v", want, repo.events)
+	}
+}
+
+func TestRetentionKeepsNumberingArchivedObjects(t *testing.T) {
+	pool := dbtest.New(t)
+	ctx := context.Background()
+	r := NewRetention(pool, t.TempDir(), 1)
+	if err := r.EnsurePartitions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	users := repository.NewUserRepository(pool)
+	alice := db.User{ID: "alice", Name: "Alice", Email: "alice@example.com", Roles: []string{"user"}}
+	if _, err := users.Create(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	alice.Name = "Alice B."
+	if _, err := users.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archives every version of alice
+	month := time.Now().UTC()
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if err := r.archive(ctx, partitionPrefix+month.Format(partitionLayout), month.AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.EnsurePartitions(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Name = "Alice C."
+	if _, err := users.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := repository.NewVersionRepository(db.New(pool)).List(ctx, db.ListVersionsParams{
+		ObjectID: "alice", ObjectType: repository.UserObjectType, RowLimit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Version != 3 {
+		t.Errorf("Expected the version after the archived ones to be 3, got %+v", versions)
+	}
+}
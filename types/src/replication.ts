@@ -1,4 +1,4 @@
-import { ReplicationPushHandlerResult, RxReplicationPullStreamItem, RxReplicationWriteToMasterRow } from "rxdb";
+import { RxReplicationPullStreamItem, RxReplicationWriteToMasterRow } from "rxdb";
 
 export interface CheckpointType {
   updated_at: string;
@@ -7,15 +7,35 @@ export interface CheckpointType {
 
 export interface GetCollectionParams {
   minUpdatedAt?: string
+  id?: string
   limit?: number
 }
 
 export interface GetCollectionResponse<TCollection>  {
   documents: TCollection[]
+  checkpoint?: CheckpointType
+}
+
+export interface ReplicationConflict<TCollection> {
+  documentId: string
+  newDocumentState: TCollection
+  // The state on the server, which RxDB's push handler returns
+  realMasterState: TCollection
+}
+
+export interface ReplicationError {
+  documentId: string
+  error: string
+  status?: number
+  // Path of the invalid field, for validation errors
+  field?: string
 }
 
 export interface PostCollectionResponse<TCollection> {
-  documents: ReplicationPushHandlerResult<TCollection>
+  // The written documents
+  documents: TCollection[]
+  conflicts: ReplicationConflict<TCollection>[]
+  errors: ReplicationError[]
 }
 
 export interface PostCollectionBody<TCollection> {
@@ -23,5 +43,6 @@ export interface PostCollectionBody<TCollection> {
 }
 
 export interface CollectionStreamEvent<TCollection> {
-  data: RxReplicationPullStreamItem<TCollection, CheckpointType>
+  // "RESYNC" when the server can't guarantee no event was missed
+  data: RxReplicationPullStreamItem<TCollection, CheckpointType> | "RESYNC"
 }
\ No newline at end of file
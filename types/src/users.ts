@@ -1,6 +1,7 @@
 import { z } from "zod";
 import { CollectionStreamEvent, GetCollectionParams, GetCollectionResponse, PostCollectionBody, PostCollectionResponse } from "./replication";
 
+// keep in sync with User.Validate in internal/types/validation.go
 export const userSchema = z.object({
   id: z.string(),
   email: z.string().email(),